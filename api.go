@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/versions"
+	"github.com/docker/docker/client"
+)
+
+// diskUsageAPIVersion is the first api version with the disk usage endpoint
+const diskUsageAPIVersion = "1.25"
+
+// apiFeature is something docker-purge uses that older daemons do not support
+type apiFeature struct {
+	// Version is the first api version with the feature
+	Version string
+	Name    string
+	// Degraded is what docker-purge does without the feature
+	Degraded string
+}
+
+// apiFeatures are the features that degrade on older daemons. The build cache and volumes are not
+// among them, docker-purge never purges them, no matter which api version the daemon speaks
+var apiFeatures = []apiFeature{
+	{Version: diskUsageAPIVersion, Name: "the disk usage endpoint", Degraded: "sizes are estimated from the container and image lists"},
+	{Version: "1.23", Name: "container mounts", Degraded: ".Mounts is empty in filters"},
+	{Version: "1.22", Name: "typed events", Degraded: "watch does not see any events"},
+}
+
+// unsupportedAPIFeatures returns the features the negotiated api version does not support
+func unsupportedAPIFeatures(dockerClient *client.Client) []apiFeature {
+	var unsupported []apiFeature
+	for _, f := range apiFeatures {
+		if versions.LessThan(dockerClient.ClientVersion(), f.Version) {
+			unsupported = append(unsupported, f)
+		}
+	}
+	return unsupported
+}
+
+// reportAPIFeatures warns about the features the negotiated api version does not support,
+// prefix tells the hosts apart
+func reportAPIFeatures(w io.Writer, prefix string, dockerClient *client.Client) {
+	for _, f := range unsupportedAPIFeatures(dockerClient) {
+		fmt.Fprintf(w, "warning: %sdocker api %s does not support %s (api %s), %s\n", prefix, dockerClient.ClientVersion(), f.Name, f.Version, f.Degraded)
+	}
+}
+
+// negotiateAPIVersion lowers the api version of the client to the version of the daemon,
+// the version is not negotiated if it was specified with override or DOCKER_API_VERSION
+func negotiateAPIVersion(dockerClient *client.Client, override string) error {
+	if override != "" {
+		dockerClient.UpdateClientVersion(override)
+		return nil
+	}
+	if os.Getenv("DOCKER_API_VERSION") != "" {
+		return nil
+	}
+	ping, err := dockerClient.Ping(context.Background())
+	if err != nil {
+		return fmt.Errorf("unable to negotiate api version: %s", err.Error())
+	}
+	if ping.APIVersion != "" && versions.LessThan(ping.APIVersion, dockerClient.ClientVersion()) {
+		dockerClient.UpdateClientVersion(ping.APIVersion)
+	}
+	return nil
+}
+
+// supportsDiskUsage reports whether the daemon has the disk usage endpoint
+func supportsDiskUsage(dockerClient *client.Client) bool {
+	return !versions.LessThan(dockerClient.ClientVersion(), diskUsageAPIVersion)
+}
+
+// dockerDiskUsage returns the disk usage of the daemon, older daemons without the disk usage
+// endpoint get an estimation from the container and image lists, shared layers are counted once per image
+func dockerDiskUsage(dockerClient *client.Client) (types.DiskUsage, error) {
+	if supportsDiskUsage(dockerClient) {
+		return dockerClient.DiskUsage(context.Background())
+	}
+	var du types.DiskUsage
+	containers, err := dockerClient.ContainerList(context.Background(), types.ContainerListOptions{All: true, Size: true})
+	if err != nil {
+		return du, err
+	}
+	for i := range containers {
+		du.Containers = append(du.Containers, &containers[i])
+	}
+	images, err := dockerClient.ImageList(context.Background(), imageListOptions)
+	if err != nil {
+		return du, err
+	}
+	for i := range images {
+		images[i].SharedSize = -1
+		du.Images = append(du.Images, &images[i])
+		du.LayersSize += images[i].Size
+	}
+	return du, nil
+}
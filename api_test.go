@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateAPIVersion(t *testing.T) {
+	docker := newFakeDocker(t)
+	defer docker.close()
+	// the disk usage endpoint does not exist in 1.24
+	docker.apiVersion = "1.24"
+	docker.containers = []types.Container{{ID: "c1", SizeRw: 10}}
+	docker.images = []types.ImageSummary{{ID: "i1", Size: 100}, {ID: "i2", Size: 50}}
+	dockerClient, err := client.NewClient(docker.host(), client.DefaultVersion, nil, nil)
+	require.Nil(t, err, "Expected no Error")
+
+	require.Nil(t, negotiateAPIVersion(dockerClient, ""))
+	require.Equal(t, "1.24", dockerClient.ClientVersion())
+	require.False(t, supportsDiskUsage(dockerClient))
+	var report strings.Builder
+	reportAPIFeatures(&report, "build-1: ", dockerClient)
+	require.Equal(t, "warning: build-1: docker api 1.24 does not support the disk usage endpoint (api 1.25), sizes are estimated from the container and image lists\n", report.String())
+
+	du, err := dockerDiskUsage(dockerClient)
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, int64(150), du.LayersSize)
+	require.Len(t, du.Containers, 1)
+	require.Equal(t, int64(10), du.Containers[0].SizeRw)
+	require.Equal(t, int64(-1), du.Images[0].SharedSize)
+
+	// an explicit version is never negotiated
+	require.Nil(t, negotiateAPIVersion(dockerClient, "1.23"))
+	require.Equal(t, "1.23", dockerClient.ClientVersion())
+	require.Len(t, unsupportedAPIFeatures(dockerClient), 1)
+	require.Nil(t, negotiateAPIVersion(dockerClient, "1.21"))
+	require.Len(t, unsupportedAPIFeatures(dockerClient), 3)
+	require.Nil(t, negotiateAPIVersion(dockerClient, "1.25"))
+	require.Empty(t, unsupportedAPIFeatures(dockerClient))
+}
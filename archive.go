@@ -0,0 +1,281 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+	units "github.com/docker/go-units"
+)
+
+// archiveIndexFile is the name of the index in the archive directory
+const archiveIndexFile = "index.json"
+
+// archiveEntry describes an image tarball in the archive directory
+type archiveEntry struct {
+	ID      string            `json:"id"`
+	File    string            `json:"file"`
+	Tags    []string          `json:"tags"`
+	Digests []string          `json:"digests"`
+	Labels  map[string]string `json:"labels"`
+	// Size is the size of the compressed tarball
+	Size int64 `json:"size"`
+	// Archived is the unix time the image was archived
+	Archived int64 `json:"archived"`
+	// Retag reports whether the tarball was saved by id, the tags are added after loading it
+	Retag bool `json:"retag,omitempty"`
+}
+
+// imageArchive saves images to compressed tarballs before they are deleted
+type imageArchive struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+	mu      sync.Mutex
+}
+
+// openImageArchive creates the archive directory, maxSize and maxAge of 0 keep the archives forever
+func openImageArchive(dir string, maxSize int64, maxAge time.Duration) (*imageArchive, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &imageArchive{dir: dir, maxSize: maxSize, maxAge: maxAge}, nil
+}
+
+func (a *imageArchive) readIndex() ([]*archiveEntry, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(a.dir, archiveIndexFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var entries []*archiveEntry
+	if err := json.Unmarshal(buf, &entries); err != nil {
+		return nil, fmt.Errorf("unable to parse archive index: %s", err.Error())
+	}
+	return entries, nil
+}
+
+// writeIndex replaces the index atomically
+func (a *imageArchive) writeIndex(entries []*archiveEntry) error {
+	buf, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(a.dir, archiveIndexFile+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(a.dir, archiveIndexFile))
+}
+
+// save streams the image into a compressed tarball and adds it to the index,
+// trashed images are archived with the tags they had before they were trashed
+func (a *imageArchive) save(dockerClient *client.Client, image image, trashed *trashEntry) error {
+	if a == nil {
+		return nil
+	}
+	var tags []string
+	for _, tag := range image.RepoTags {
+		if tag != "<none>:<none>" {
+			tags = append(tags, tag)
+		}
+	}
+	// saving by tag keeps the tags in the tarball, so they come back on load
+	refs := tags
+	retag := false
+	if trashed != nil {
+		// the original tags are gone and the trash tag must not come back on load
+		tags = trashed.Tags
+		refs = nil
+		retag = len(tags) > 0
+	}
+	if len(refs) == 0 {
+		refs = []string{image.ID}
+	}
+
+	rc, err := dockerClient.ImageSave(context.Background(), refs)
+	if err != nil {
+		return fmt.Errorf("unable to archive image %s: %s", image.ID, err.Error())
+	}
+	defer rc.Close()
+
+	// nanoseconds keep two archives of the same image apart, the rename would overwrite the first one otherwise
+	name := fmt.Sprintf("%s-%d.tar.gz", shortID(image.ID), time.Now().UnixNano())
+	tmp, err := ioutil.TempFile(a.dir, name+".tmp")
+	if err != nil {
+		return fmt.Errorf("unable to archive image %s: %s", image.ID, err.Error())
+	}
+	gz := gzip.NewWriter(tmp)
+	_, err = io.Copy(gz, rc)
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(a.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("unable to archive image %s: %s", image.ID, err.Error())
+	}
+	fi, err := os.Stat(filepath.Join(a.dir, name))
+	if err != nil {
+		return fmt.Errorf("unable to archive image %s: %s", image.ID, err.Error())
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entries, err := a.readIndex()
+	if err != nil {
+		return err
+	}
+	entries = append(entries, &archiveEntry{
+		ID:       image.ID,
+		File:     name,
+		Tags:     tags,
+		Digests:  image.RepoDigests,
+		Labels:   image.Labels,
+		Size:     fi.Size(),
+		Archived: time.Now().Unix(),
+		Retag:    retag,
+	})
+	return a.writeIndex(entries)
+}
+
+// prune deletes the archives that are older than maxAge and the oldest archives
+// until the archives fit into maxSize. Archives created since the start of the run are never deleted,
+// they belong to images the run just deleted.
+func (a *imageArchive) prune(started time.Time) error {
+	if a == nil || (a.maxSize <= 0 && a.maxAge <= 0) {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entries, err := a.readIndex()
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Archived < entries[j].Archived
+	})
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+	deadline := time.Now().Add(-a.maxAge).Unix()
+	var kept []*archiveEntry
+	for _, e := range entries {
+		current := e.Archived >= started.Unix()
+		if !current && ((a.maxAge > 0 && e.Archived < deadline) || (a.maxSize > 0 && total > a.maxSize)) {
+			if err := os.Remove(filepath.Join(a.dir, e.File)); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "unable to delete archive %s: %s\n", e.File, err.Error())
+				kept = append(kept, e)
+				continue
+			}
+			total -= e.Size
+			continue
+		}
+		kept = append(kept, e)
+	}
+	if a.maxSize > 0 && total > a.maxSize {
+		fmt.Fprintf(os.Stderr, "warning: the archives use %s, more than archive.max-size %s, the archives of this run are kept\n",
+			units.HumanSize(float64(total)), units.HumanSize(float64(a.maxSize)))
+	}
+	return a.writeIndex(kept)
+}
+
+// find returns the newest archive of the image with the id, id prefix or tag
+func (a *imageArchive) find(ref string) (*archiveEntry, error) {
+	entries, err := a.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimPrefix(ref, "sha256:")
+	var found *archiveEntry
+	for _, e := range entries {
+		if strings.HasPrefix(strings.TrimPrefix(e.ID, "sha256:"), prefix) || containsString(e.Tags, ref) {
+			if found != nil && found.ID != e.ID {
+				return nil, fmt.Errorf("`%s' matches more than one archived image", ref)
+			}
+			if found == nil || e.Archived >= found.Archived {
+				found = e
+			}
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no archived image `%s'", ref)
+	}
+	return found, nil
+}
+
+// load loads the archived image back into docker
+func (a *imageArchive) load(dockerClient *client.Client, e *archiveEntry) error {
+	f, err := os.Open(filepath.Join(a.dir, e.File))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("unable to read archive %s: %s", e.File, err.Error())
+	}
+	resp, err := dockerClient.ImageLoad(context.Background(), gz, true)
+	if err != nil {
+		return fmt.Errorf("unable to load archive %s: %s", e.File, err.Error())
+	}
+	defer resp.Body.Close()
+	if !resp.JSON {
+		if _, err := io.Copy(ioutil.Discard, resp.Body); err != nil {
+			return err
+		}
+	} else {
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var msg struct {
+				Error string `json:"error"`
+			}
+			if err := dec.Decode(&msg); err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			if msg.Error != "" {
+				return fmt.Errorf("unable to load archive %s: %s", e.File, msg.Error)
+			}
+		}
+	}
+	if !e.Retag {
+		return nil
+	}
+	for _, tag := range e.Tags {
+		if err := dockerClient.ImageTag(context.Background(), e.ID, tag); err != nil {
+			return fmt.Errorf("unable to tag image %s as %s: %s", e.ID, tag, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageArchive(t *testing.T) {
+	docker := newFakeDocker(t)
+	defer docker.close()
+	dockerClient := docker.client(t)
+
+	dir, removeDir := newTempDir(t)
+	defer removeDir()
+	a, err := openImageArchive(dir, 0, 0)
+	require.Nil(t, err, "Expected no Error")
+	started := time.Now()
+
+	require.Nil(t, a.save(dockerClient, image{ImageSummary: types.ImageSummary{
+		ID:          "sha256:0123456789abcdef",
+		RepoTags:    []string{"firefox:latest"},
+		RepoDigests: []string{"firefox@sha256:fedcba"},
+		Labels:      map[string]string{"team": "web"},
+	}}, nil))
+	require.Equal(t, []string{"firefox:latest"}, docker.saved)
+	require.Nil(t, a.save(dockerClient, image{ImageSummary: types.ImageSummary{ID: "sha256:aaaaaaaaaaaaaaaa", RepoTags: []string{"<none>:<none>"}}}, nil))
+	require.Equal(t, []string{"firefox:latest", "sha256:aaaaaaaaaaaaaaaa"}, docker.saved)
+
+	entries, err := a.readIndex()
+	require.Nil(t, err, "Expected no Error")
+	require.Len(t, entries, 2)
+	require.Equal(t, []string{"firefox@sha256:fedcba"}, entries[0].Digests)
+	require.Equal(t, "web", entries[0].Labels["team"])
+	require.Nil(t, entries[1].Tags)
+
+	e, err := a.find("firefox:latest")
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, "sha256:0123456789abcdef", e.ID)
+	require.Nil(t, a.load(dockerClient, e))
+	require.Equal(t, []string{"tarball of firefox:latest"}, docker.loaded)
+
+	_, err = a.find("bbbb")
+	require.NotNil(t, err)
+
+	// the oldest archive is deleted first
+	entries[0].Archived = time.Now().Add(-time.Hour).Unix()
+	require.Nil(t, a.writeIndex(entries))
+	a.maxSize = entries[1].Size
+	require.Nil(t, a.prune(started))
+	entries, err = a.readIndex()
+	require.Nil(t, err, "Expected no Error")
+	require.Len(t, entries, 1)
+	require.Equal(t, "sha256:aaaaaaaaaaaaaaaa", entries[0].ID)
+	files, err := ioutil.ReadDir(dir)
+	require.Nil(t, err, "Expected no Error")
+	require.Len(t, files, 2)
+
+	// the archives of the current run are kept even if they exceed the limit on their own
+	a.maxSize = 1
+	require.Nil(t, a.prune(started))
+	entries, err = a.readIndex()
+	require.Nil(t, err, "Expected no Error")
+	require.Len(t, entries, 1)
+
+	// a later run prunes them
+	require.Nil(t, a.prune(started.Add(time.Hour)))
+	entries, err = a.readIndex()
+	require.Nil(t, err, "Expected no Error")
+	require.Len(t, entries, 0)
+}
+
+func TestImageArchiveSameImageTwice(t *testing.T) {
+	docker := newFakeDocker(t)
+	defer docker.close()
+	dockerClient := docker.client(t)
+
+	dir, removeDir := newTempDir(t)
+	defer removeDir()
+	a, err := openImageArchive(dir, 0, 0)
+	require.Nil(t, err, "Expected no Error")
+
+	i := image{ImageSummary: types.ImageSummary{ID: "sha256:0123456789abcdef", RepoTags: []string{"firefox:latest"}}}
+	require.Nil(t, a.save(dockerClient, i, nil))
+	require.Nil(t, a.save(dockerClient, i, nil))
+
+	entries, err := a.readIndex()
+	require.Nil(t, err, "Expected no Error")
+	require.Len(t, entries, 2)
+	require.NotEqual(t, entries[0].File, entries[1].File)
+	files, err := ioutil.ReadDir(dir)
+	require.Nil(t, err, "Expected no Error")
+	require.Len(t, files, 3)
+}
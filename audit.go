@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+// auditRecord is a single line of the audit log
+type auditRecord struct {
+	Time time.Time `json:"time"`
+	Host string    `json:"host"`
+	// DockerHost is the docker host the entity was purged on, the name of the host if several hosts are purged
+	DockerHost string            `json:"dockerHost"`
+	Kind       string            `json:"kind"`
+	ID         string            `json:"id"`
+	Names      []string          `json:"names"`
+	Labels     map[string]string `json:"labels"`
+	Rule       string            `json:"rule"`
+	Filter     string            `json:"filter"`
+	Invoker    string            `json:"invoker"`
+	Dry        bool              `json:"dry"`
+	Result     string            `json:"result"`
+	Error      string            `json:"error,omitempty"`
+}
+
+const (
+	auditResultDeleted = "deleted"
+	auditResultTrashed = "trashed"
+	auditResultFailed  = "failed"
+	auditResultDry     = "dry"
+)
+
+// auditLog appends a record for every purged entity to a file and/or syslog
+type auditLog struct {
+	mu         sync.Mutex
+	host       string
+	dockerHost string
+	file       *os.File
+	syslog     io.Writer
+}
+
+// openAuditLog opens the audit log file in append mode, path may be empty if only syslog is used.
+// dockerHost is recorded unless the purge names its host.
+func openAuditLog(path string, useSyslog bool, dockerHost string) (*auditLog, error) {
+	a := &auditLog{dockerHost: dockerHost}
+	a.host, _ = os.Hostname()
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		a.file = f
+	}
+	if useSyslog {
+		w, err := openAuditSyslog()
+		if err != nil {
+			a.close()
+			return nil, err
+		}
+		a.syslog = w
+	}
+	return a, nil
+}
+
+// record writes the result of purging an entity, errors are reported but do not stop the purge
+func (a *auditLog) record(origin purgeOrigin, result purgeResult, labels map[string]string) {
+	if a == nil {
+		return
+	}
+	dockerHost := origin.Host
+	if dockerHost == "" {
+		dockerHost = a.dockerHost
+	}
+	r := auditRecord{
+		Time:       time.Now().UTC(),
+		Host:       a.host,
+		DockerHost: dockerHost,
+		Kind:       result.Kind,
+		ID:         result.ID,
+		Names:      result.Names,
+		Labels:     labels,
+		Rule:       origin.Rule,
+		Filter:     origin.Filter,
+		Invoker:    origin.Invoker,
+		Dry:        origin.Dry,
+		Result:     auditResultDeleted,
+		Error:      result.Error,
+	}
+	if origin.Dry {
+		r.Result = auditResultDry
+	} else if result.Error != "" {
+		r.Result = auditResultFailed
+	} else if origin.Trash && result.Kind != "network" {
+		r.Result = auditResultTrashed
+	}
+	buf, err := json.Marshal(r)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to encode audit record: %s\n", err.Error())
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file != nil {
+		// a single write per record keeps lines intact if several processes share the file
+		if _, err := a.file.Write(append(buf, '\n')); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to write audit log: %s\n", err.Error())
+		} else if err := a.file.Sync(); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to sync audit log: %s\n", err.Error())
+		}
+	}
+	if a.syslog != nil {
+		if _, err := a.syslog.Write(buf); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to write audit record to syslog: %s\n", err.Error())
+		}
+	}
+}
+
+func (a *auditLog) close() {
+	if a == nil {
+		return
+	}
+	if a.file != nil {
+		a.file.Close()
+	}
+	if c, ok := a.syslog.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+// currentUser returns the name of the user running docker-purge,
+// including the user that invoked sudo
+func currentUser() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" && sudoUser != name {
+		return fmt.Sprintf("%s (sudo as %s)", sudoUser, name)
+	}
+	return name
+}
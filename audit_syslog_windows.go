@@ -0,0 +1,10 @@
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+func openAuditSyslog() (io.Writer, error) {
+	return nil, errors.New("syslog is not supported on windows")
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, removeDir := newTempDir(t)
+	defer removeDir()
+	path := filepath.Join(dir, "audit.log")
+
+	a, err := openAuditLog(path, false, "tcp://build-1:2376")
+	require.Nil(t, err, "Expected no Error")
+	origin := purgeOrigin{Rule: "cli", Invoker: "alice", Filter: ".IsContainer==true"}
+	a.record(origin, purgeResult{Kind: "container", ID: "c1", Names: []string{"/firefox"}}, map[string]string{"team": "web"})
+	a.record(origin, purgeResult{Kind: "container", ID: "c2", Error: "conflict"}, nil)
+	origin.Dry = true
+	a.record(origin, purgeResult{Kind: "image", ID: "i1"}, nil)
+	a.close()
+
+	// reopening must append instead of truncating
+	a, err = openAuditLog(path, false, "tcp://build-1:2376")
+	require.Nil(t, err, "Expected no Error")
+	a.record(origin, purgeResult{Kind: "network", ID: "n1"}, nil)
+	// several hosts are named by the purge
+	origin.Host = "build-2"
+	a.record(origin, purgeResult{Kind: "network", ID: "n2"}, nil)
+	a.close()
+
+	f, err := os.Open(path)
+	require.Nil(t, err, "Expected no Error")
+	defer f.Close()
+	var records []auditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r auditRecord
+		require.Nil(t, json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+	require.Len(t, records, 5)
+	require.Equal(t, "c1", records[0].ID)
+	require.Equal(t, auditResultDeleted, records[0].Result)
+	require.Equal(t, "web", records[0].Labels["team"])
+	require.Equal(t, "alice", records[0].Invoker)
+	require.Equal(t, ".IsContainer==true", records[0].Filter)
+	require.Equal(t, auditResultFailed, records[1].Result)
+	require.Equal(t, "conflict", records[1].Error)
+	require.Equal(t, auditResultDry, records[2].Result)
+	require.True(t, records[2].Dry)
+	require.Equal(t, "n1", records[3].ID)
+	require.Equal(t, "tcp://build-1:2376", records[3].DockerHost)
+	require.Equal(t, "build-2", records[4].DockerHost)
+}
+
+func TestAuditLogDisabled(t *testing.T) {
+	var a *auditLog
+	a.record(purgeOrigin{}, purgeResult{Kind: "container", ID: "c1"}, nil)
+	a.close()
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/client"
+	units "github.com/docker/go-units"
+)
+
+// budgetCandidate is an entity that can be removed to free up space
+type budgetCandidate struct {
+	container *container
+	image     *image
+	// lastUsed is used to sort the candidates, least recently used first
+	lastUsed int64
+	size     int64
+}
+
+func handleBudget(dockerClient *client.Client, origin purgeOrigin) {
+	budget, err := units.FromHumanSize(*budgetFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid budget `%s': %s\n", *budgetFlag, err.Error())
+		os.Exit(1)
+	}
+
+	if *dryRunFlag {
+		fmt.Fprintln(os.Stdout, "Dry mode on")
+	}
+
+	if err := purgeToBudget(dockerClient, budget, *filterArg, origin); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+// purgeToBudget removes the least recently used images (and stopped containers if enabled)
+// that match the filter until the used space is below the budget,
+// the build cache is not part of the budget because docker-purge cannot list or prune it
+func purgeToBudget(dockerClient *client.Client, budget int64, filter string, origin purgeOrigin) error {
+	origin.Rule = "budget"
+	origin.Filter = filter
+	origin.Dry = *dryRunFlag
+	origin.started = time.Now()
+	sizes, err := getDiskUsage(dockerClient)
+	if err != nil {
+		return err
+	}
+
+	usage := sizes.layers
+	if *budgetContainersFlag {
+		for _, size := range sizes.containers {
+			usage += size
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "Using %s of %s, the build cache is not budgeted\n", units.HumanSize(float64(usage)), units.HumanSize(float64(budget)))
+	if usage <= budget {
+		return nil
+	}
+
+	allContainers, err := selectContainers(dockerClient, "")
+	if err != nil {
+		return err
+	}
+
+	// count the containers that use an image, removing a container frees its image
+	imageUsers := make(map[string]int)
+	for _, c := range allContainers {
+		imageUsers[c.ImageID]++
+	}
+
+	var candidates []*budgetCandidate
+
+	if *budgetContainersFlag {
+		containers, err := selectContainers(dockerClient, filter)
+		if err != nil {
+			return err
+		}
+		for i := range containers {
+			c := &containers[i]
+			if c.InUse || hasKeepLabel(c.Labels) {
+				continue
+			}
+			candidates = append(candidates, &budgetCandidate{
+				container: c,
+				lastUsed:  c.Created,
+				size:      sizes.containers[c.ID],
+			})
+		}
+	}
+
+	images, err := selectImages(dockerClient, origin.state, filter)
+	if err != nil {
+		return err
+	}
+	for i := range images {
+		img := &images[i]
+		if hasKeepLabel(img.Labels) {
+			continue
+		}
+		lastUsed := img.LastUsed
+		if lastUsed == 0 {
+			lastUsed = img.Created
+		}
+		candidates = append(candidates, &budgetCandidate{
+			image:    img,
+			lastUsed: lastUsed,
+			size:     sizes.images[img.ID],
+		})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].lastUsed < candidates[j].lastUsed
+	})
+
+	// an image can only be removed after all containers using it are gone,
+	// so repeat until nothing changes anymore
+	for progress := true; progress && usage > budget; {
+		progress = false
+		for _, candidate := range candidates {
+			if usage <= budget {
+				break
+			}
+			var removed bool
+			if candidate.container != nil {
+				if removed = purgeContainer(dockerClient, candidate.container, origin); removed {
+					imageUsers[candidate.container.ImageID]--
+				}
+			} else {
+				if candidate.image == nil || imageUsers[candidate.image.ID] > 0 {
+					continue
+				}
+				removed = purgeImage(dockerClient, candidate.image, origin)
+			}
+			// never try a candidate twice, even if the removal failed
+			candidate.container = nil
+			candidate.image = nil
+			if removed {
+				usage -= candidate.size
+				progress = true
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "Using %s of %s after purge\n", units.HumanSize(float64(usage)), units.HumanSize(float64(budget)))
+	return nil
+}
+
+// diskUsage holds the space used by the docker entities
+type diskUsage struct {
+	layers int64
+	// images maps an image id to the space that is freed when the image is removed
+	images map[string]int64
+	// containers maps a container id to the size of its writable layer
+	containers map[string]int64
+}
+
+func getDiskUsage(dockerClient *client.Client) (*diskUsage, error) {
+	du, err := dockerDiskUsage(dockerClient)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := diskUsage{
+		layers:     du.LayersSize,
+		images:     make(map[string]int64, len(du.Images)),
+		containers: make(map[string]int64, len(du.Containers)),
+	}
+	for _, i := range du.Images {
+		// SharedSize is -1 if the daemon did not calculate it
+		if i.SharedSize >= 0 {
+			sizes.images[i.ID] = i.Size - i.SharedSize
+		} else {
+			sizes.images[i.ID] = i.Size
+		}
+	}
+	for _, c := range du.Containers {
+		sizes.containers[c.ID] = c.SizeRw
+	}
+	return &sizes, nil
+}
+
+func hasKeepLabel(labels map[string]string) bool {
+	if *budgetKeepLabelFlag == "" {
+		return false
+	}
+	_, ok := labels[*budgetKeepLabelFlag]
+	return ok
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeToBudget(t *testing.T) {
+	defer func(label string) { *budgetKeepLabelFlag = label }(*budgetKeepLabelFlag)
+	*budgetKeepLabelFlag = "docker-purge.keep"
+
+	docker := newFakeDocker(t)
+	defer docker.close()
+	docker.containers = []types.Container{
+		{ID: "c1", ImageID: "sha256:i1", State: "running"},
+	}
+	docker.images = []types.ImageSummary{
+		{ID: "sha256:i5", Created: 500, Size: 10},
+		{ID: "sha256:i1", Created: 100, Size: 10},
+		{ID: "sha256:i2", Created: 200, Size: 10, Labels: map[string]string{"docker-purge.keep": ""}},
+		{ID: "sha256:i4", Created: 400, Size: 10},
+		{ID: "sha256:i3", Created: 300, Size: 10},
+	}
+
+	// i1 is in use and i2 is kept, the least recently used of the others are removed until 30 bytes are used
+	require.Nil(t, purgeToBudget(docker.client(t), 30, "", purgeOrigin{}))
+	require.Equal(t, []string{"DELETE /images/sha256:i3?noprune=1", "DELETE /images/sha256:i4?noprune=1"}, docker.mutations())
+
+	// nothing is removed within the budget
+	require.Nil(t, purgeToBudget(docker.client(t), 30, "", purgeOrigin{}))
+	require.Len(t, docker.mutations(), 2)
+}
+
+func TestPurgeToBudgetContainers(t *testing.T) {
+	defer func(containers bool) { *budgetContainersFlag = containers }(*budgetContainersFlag)
+	*budgetContainersFlag = true
+
+	docker := newFakeDocker(t)
+	defer docker.close()
+	docker.containers = []types.Container{
+		{ID: "c1", ImageID: "sha256:i1", State: "exited", Created: 50, SizeRw: 5},
+		{ID: "c2", ImageID: "sha256:i3", State: "paused", Created: 10, SizeRw: 5},
+		{ID: "c3", ImageID: "sha256:i3", State: "restarting", Created: 20, SizeRw: 5},
+	}
+	docker.images = []types.ImageSummary{
+		{ID: "sha256:i1", Created: 100, Size: 10},
+		{ID: "sha256:i3", Created: 300, Size: 10},
+	}
+
+	// removing the stopped container frees its image, paused and restarting containers are in use
+	require.Nil(t, purgeToBudget(docker.client(t), 10, "", purgeOrigin{}))
+	require.Equal(t, []string{"DELETE /containers/c1", "DELETE /images/sha256:i1?noprune=1"}, docker.mutations())
+}
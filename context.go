@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// defaultContext is the docker context that uses the environment
+const defaultContext = "default"
+
+// dockerConfigDir returns the directory of the docker cli configuration
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		home = os.Getenv("USERPROFILE")
+	}
+	return filepath.Join(home, ".docker")
+}
+
+// currentContext returns the context selected by DOCKER_CONTEXT or the docker cli configuration
+func currentContext(configDir string) (string, error) {
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" {
+		return name, nil
+	}
+	buf, err := ioutil.ReadFile(filepath.Join(configDir, "config.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return defaultContext, nil
+		}
+		return "", err
+	}
+	var config struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if err := json.Unmarshal(buf, &config); err != nil {
+		return "", fmt.Errorf("unable to parse docker config: %s", err.Error())
+	}
+	if config.CurrentContext == "" {
+		return defaultContext, nil
+	}
+	return config.CurrentContext, nil
+}
+
+// contextMeta is the metadata the docker cli stores for a context
+type contextMeta struct {
+	Name      string `json:"Name"`
+	Endpoints map[string]struct {
+		Host          string `json:"Host"`
+		SkipTLSVerify bool   `json:"SkipTLSVerify"`
+	} `json:"Endpoints"`
+}
+
+// contextID returns the name of the directories the docker cli stores the metadata
+// and tls files of a context in, it is the sha256 of the context name
+func contextID(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadContext returns the docker host of a context
+func loadContext(configDir, name string) (*dockerHost, error) {
+	id := contextID(name)
+	buf, err := ioutil.ReadFile(filepath.Join(configDir, "contexts", "meta", id, "meta.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("context `%s' does not exist", name)
+		}
+		return nil, err
+	}
+	var meta contextMeta
+	if err := json.Unmarshal(buf, &meta); err != nil {
+		return nil, fmt.Errorf("unable to parse context `%s': %s", name, err.Error())
+	}
+	endpoint, ok := meta.Endpoints["docker"]
+	if !ok || endpoint.Host == "" {
+		return nil, fmt.Errorf("context `%s' has no docker endpoint", name)
+	}
+
+	h := &dockerHost{Name: name, Host: endpoint.Host}
+	tlsDir := filepath.Join(configDir, "contexts", "tls", id, "docker")
+	for path, file := range map[*string]string{&h.TLSCACert: "ca.pem", &h.TLSCert: "cert.pem", &h.TLSKey: "key.pem"} {
+		if _, err := os.Stat(filepath.Join(tlsDir, file)); err == nil {
+			*path = filepath.Join(tlsDir, file)
+		}
+	}
+	// like the docker cli, a context uses tls if it has tls files or skips the verification
+	h.TLS = h.TLSCACert != "" || h.TLSCert != "" || h.TLSKey != "" || endpoint.SkipTLSVerify
+	h.TLSVerify = h.TLS && !endpoint.SkipTLSVerify
+	return h, nil
+}
+
+// envDockerHost returns the DOCKER_HOST if the docker client cannot handle it itself
+func envDockerHost() *dockerHost {
+	if host := os.Getenv("DOCKER_HOST"); isSSHHost(host) {
+		return &dockerHost{Host: host}
+	}
+	return nil
+}
+
+// envHost returns the docker host of the environment or the current context,
+// nil if the docker client can handle the environment itself
+func envHost() (*dockerHost, error) {
+	if os.Getenv("DOCKER_HOST") != "" {
+		return envDockerHost(), nil
+	}
+	configDir := dockerConfigDir()
+	name, err := currentContext(configDir)
+	if err != nil {
+		return nil, err
+	}
+	if name == defaultContext {
+		return nil, nil
+	}
+	return loadContext(configDir, name)
+}
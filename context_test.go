@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadContext(t *testing.T) {
+	dir, removeDir := newTempDir(t)
+	defer removeDir()
+
+	name, err := currentContext(dir)
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, defaultContext, name)
+
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"currentContext": "build"}`), 0600))
+	name, err = currentContext(dir)
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, "build", name)
+
+	_, err = loadContext(dir, "build")
+	require.NotNil(t, err)
+
+	id := contextID("build")
+	require.Nil(t, os.MkdirAll(filepath.Join(dir, "contexts", "meta", id), 0700))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "contexts", "meta", id, "meta.json"),
+		[]byte(`{"Name":"build","Endpoints":{"docker":{"Host":"tcp://build:2376","SkipTLSVerify":false}}}`), 0600))
+	tlsDir := filepath.Join(dir, "contexts", "tls", id, "docker")
+	require.Nil(t, os.MkdirAll(tlsDir, 0700))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(tlsDir, "ca.pem"), nil, 0600))
+
+	h, err := loadContext(dir, "build")
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, "build", h.Name)
+	require.Equal(t, "tcp://build:2376", h.Host)
+	require.Equal(t, filepath.Join(tlsDir, "ca.pem"), h.TLSCACert)
+	require.Empty(t, h.TLSCert)
+	require.True(t, h.TLS)
+	require.True(t, h.TLSVerify)
+
+	// a client certificate without a ca is verified against the system roots
+	require.Nil(t, os.Remove(filepath.Join(tlsDir, "ca.pem")))
+	require.Nil(t, ioutil.WriteFile(filepath.Join(tlsDir, "cert.pem"), nil, 0600))
+	h, err = loadContext(dir, "build")
+	require.Nil(t, err, "Expected no Error")
+	require.Empty(t, h.TLSCACert)
+	require.True(t, h.TLSVerify)
+
+	// contexts without tls files connect without tls
+	require.Nil(t, os.RemoveAll(tlsDir))
+	h, err = loadContext(dir, "build")
+	require.Nil(t, err, "Expected no Error")
+	require.False(t, h.TLS)
+	require.False(t, h.TLSVerify)
+}
+
+func TestSSHCommand(t *testing.T) {
+	cmd, err := sshCommand("ssh://deploy@build-1:2222")
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, []string{"ssh", "-l", "deploy", "-p", "2222", "--", "build-1", "docker", "system", "dial-stdio"}, cmd.Args)
+
+	cmd, err = sshCommand("ssh://build-1")
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, []string{"ssh", "--", "build-1", "docker", "system", "dial-stdio"}, cmd.Args)
+
+	_, err = sshCommand("ssh://build-1/var/run/docker.sock")
+	require.NotNil(t, err)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Eun/docker-purge/jq"
+	"github.com/docker/docker/client"
+)
+
+// daemonConfig is the config file of the daemon command
+type daemonConfig struct {
+	Policies []*daemonPolicy `json:"policies"`
+	// Pressure configures the emergency policy that runs when the disk space is low
+	Pressure *pressureConfig `json:"pressure"`
+}
+
+// daemonPolicy is a policy that runs on a schedule
+type daemonPolicy struct {
+	policy
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+
+	schedule *schedule
+	next     time.Time
+}
+
+// loadDaemonConfig reads and checks the config, policies can only trash with a state
+func loadDaemonConfig(path string, state *stateStore) (*daemonConfig, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config daemonConfig
+	if err := json.Unmarshal(buf, &config); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", path, err.Error())
+	}
+
+	names := make(map[string]bool)
+	for i, p := range config.Policies {
+		if p.Name == "" {
+			p.Name = fmt.Sprintf("policy-%d", i+1)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("duplicate policy name `%s'", p.Name)
+		}
+		names[p.Name] = true
+
+		if p.schedule, err = parseSchedule(p.Schedule); err != nil {
+			return nil, fmt.Errorf("policy %s: %s", p.Name, err.Error())
+		}
+		if !jq.IsValidFilter(p.Filter) {
+			return nil, fmt.Errorf("policy %s: invalid filter `%s'", p.Name, p.Filter)
+		}
+		warnUnknownFields("policy "+p.Name+": ", p.Filter)
+		if p.Trash && state == nil {
+			return nil, fmt.Errorf("policy %s: the trash requires --state-file", p.Name)
+		}
+	}
+
+	if config.Pressure, err = config.Pressure.parse(); err != nil {
+		return nil, fmt.Errorf("pressure: %s", err.Error())
+	}
+	if config.Pressure != nil && !jq.IsValidFilter(config.Pressure.Policy.Filter) {
+		return nil, fmt.Errorf("pressure: invalid filter `%s'", config.Pressure.Policy.Filter)
+	}
+	return &config, nil
+}
+
+// daemon runs the policies of a config on their schedules
+type daemon struct {
+	dockerClient *client.Client
+	// dockerHost is the docker host the notifications name
+	dockerHost string
+	// origin holds the stores the policies purge with, every run sets its rule
+	origin purgeOrigin
+	config *daemonConfig
+	// stopPressure stops the disk pressure detection of the current config
+	stopPressure context.CancelFunc
+
+	mu sync.Mutex
+	// running holds the names of the policies that are currently running
+	running map[string]bool
+	wg      sync.WaitGroup
+}
+
+func handleDaemon(dockerClient *client.Client, dockerHost string, origin purgeOrigin) {
+	config, err := loadDaemonConfig(*daemonConfigFlag, origin.state)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	d := &daemon{
+		dockerClient: dockerClient,
+		dockerHost:   dockerHost,
+		origin:       origin,
+		running:      make(map[string]bool),
+	}
+	d.setConfig(config)
+
+	if *daemonMetricsListenFlag != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", origin.metrics.handler(dockerClient, *metricsLabelFlag))
+		go func() {
+			if err := http.ListenAndServe(*daemonMetricsListenFlag, mux); err != nil {
+				fmt.Fprintf(os.Stderr, "unable to serve metrics: %s\n", err.Error())
+				os.Exit(1)
+			}
+		}()
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	for {
+		timer := time.NewTimer(time.Until(d.nextRun()))
+		select {
+		case now := <-timer.C:
+			d.runDue(now)
+		case <-hup:
+			timer.Stop()
+			config, err := loadDaemonConfig(*daemonConfigFlag, d.origin.state)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "unable to reload config, keeping the old one: %s\n", err.Error())
+				continue
+			}
+			d.setConfig(config)
+			fmt.Fprintf(os.Stdout, "reloaded config %s\n", *daemonConfigFlag)
+		case <-stop:
+			timer.Stop()
+			d.stopPressure()
+			// let running policies finish
+			d.wg.Wait()
+			return
+		}
+	}
+}
+
+func (d *daemon) setConfig(config *daemonConfig) {
+	now := time.Now()
+	for _, p := range config.Policies {
+		p.next = p.schedule.next(now)
+		fmt.Fprintf(os.Stdout, "policy %s: next run at %s\n", p.Name, p.next.Format(time.RFC3339))
+	}
+	d.config = config
+
+	if d.stopPressure != nil {
+		d.stopPressure()
+	}
+	var ctx context.Context
+	ctx, d.stopPressure = context.WithCancel(context.Background())
+	if config.Pressure != nil {
+		go watchPressure(ctx, d.dockerClient, config.Pressure, d.origin)
+	}
+}
+
+// nextRun returns the time the next policy is due
+func (d *daemon) nextRun() time.Time {
+	// without any policy there is nothing to do but waiting for a reload
+	next := time.Now().Add(24 * time.Hour)
+	for _, p := range d.config.Policies {
+		if !p.next.IsZero() && p.next.Before(next) {
+			next = p.next
+		}
+	}
+	return next
+}
+
+// runDue starts all policies that are due, policies that are still running are skipped
+func (d *daemon) runDue(now time.Time) {
+	for _, p := range d.config.Policies {
+		if p.next.IsZero() || p.next.After(now) {
+			continue
+		}
+		p.next = p.schedule.next(now)
+
+		d.mu.Lock()
+		if d.running[p.Name] {
+			d.mu.Unlock()
+			fmt.Fprintf(os.Stderr, "policy %s: skipping run, previous run is still in progress\n", p.Name)
+			continue
+		}
+		d.running[p.Name] = true
+		d.mu.Unlock()
+
+		d.wg.Add(1)
+		go func(p daemonPolicy) {
+			defer d.wg.Done()
+			d.run(&p)
+			d.mu.Lock()
+			delete(d.running, p.Name)
+			d.mu.Unlock()
+		}(*p)
+	}
+}
+
+func (d *daemon) run(p *daemonPolicy) {
+	start := time.Now()
+	if err := refreshState(d.dockerClient, d.origin.state); err != nil {
+		fmt.Fprintf(os.Stderr, "policy %s: %s\n", p.Name, err.Error())
+	}
+	nt := flagNotifier()
+	var used int64
+	if nt != nil {
+		used = nt.usedSpace(d.dockerClient, p.policy)
+	}
+	origin := d.origin
+	origin.Rule = p.Name
+	summary, err := runPurge(d.dockerClient, p.policy, origin)
+	d.origin.metrics.observeRun(p.Name, p.policy, summary, err, time.Since(start))
+	if nt != nil {
+		nt.notify(newNotification(d.dockerHost, p.Name, p.policy, start, summary, err, used, nt.usedSpace(d.dockerClient, p.policy)))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "policy %s: %s\n", p.Name, err.Error())
+		return
+	}
+	verb := "deleted"
+	if p.Dry {
+		verb = "would delete"
+	}
+	fmt.Fprintf(os.Stdout, "policy %s: %s %d containers, %d images, %d networks, %d failed (took %s)\n",
+		p.Name, verb, summary.Containers, summary.Images, summary.Networks, summary.Failed, time.Since(start))
+}
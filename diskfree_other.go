@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!dragonfly
+
+package main
+
+import (
+	"errors"
+	"runtime"
+)
+
+// diskFree is not supported on windows and the platforms without statfs(2)
+func diskFree(path string) (*diskStats, error) {
+	return nil, errors.New("disk pressure detection is not supported on " + runtime.GOOS)
+}
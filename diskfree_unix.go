@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd || dragonfly
+// +build linux darwin freebsd dragonfly
+
+package main
+
+import "syscall"
+
+// diskFree returns the free and total space and inodes of the filesystem the path is on
+func diskFree(path string) (*diskStats, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return nil, err
+	}
+	// Bavail is signed on freebsd and dragonfly, it is negative there once the reserved blocks are in use
+	avail := int64(st.Bavail)
+	if avail < 0 {
+		avail = 0
+	}
+	return &diskStats{
+		FreeBytes:   uint64(avail) * uint64(st.Bsize),
+		TotalBytes:  uint64(st.Blocks) * uint64(st.Bsize),
+		FreeInodes:  uint64(st.Ffree),
+		TotalInodes: uint64(st.Files),
+	}, nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMain runs the command line tool instead of the tests if DOCKER_PURGE_E2E is set,
+// this way the end to end tests run the real cli with fresh flags and exit codes
+func TestMain(m *testing.M) {
+	if os.Getenv("DOCKER_PURGE_E2E") == "1" {
+		main()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+type cliResult struct {
+	stdout string
+	stderr string
+	code   int
+}
+
+// runCLI runs docker-purge with the arguments against the fake docker daemon
+func runCLI(t *testing.T, docker *fakeDocker, args ...string) cliResult {
+	configDir, removeConfigDir := newTempDir(t)
+	defer removeConfigDir()
+
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = []string{
+		"DOCKER_PURGE_E2E=1",
+		"DOCKER_HOST=" + docker.host(),
+		"DOCKER_CONFIG=" + configDir,
+		"PATH=" + os.Getenv("PATH"),
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	result := cliResult{stdout: stdout.String(), stderr: stderr.String()}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		result.code = exitErr.Sys().(syscall.WaitStatus).ExitStatus()
+	} else {
+		require.Nil(t, err, "Expected no Error")
+	}
+	return result
+}
+
+// newTempDir creates a temporary directory, the returned function removes it
+func newTempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "docker-purge")
+	require.Nil(t, err, "Expected no Error")
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// newE2EDocker returns a fake daemon with a running and two exited containers,
+// an image used by the running container, a dangling image, a network and a volume
+func newE2EDocker(t *testing.T) *fakeDocker {
+	docker := newFakeDocker(t)
+	docker.containers = []types.Container{
+		{ID: "c1", Names: []string{"/firefox"}, Image: "firefox:latest", ImageID: "sha256:i1", State: "running"},
+		{ID: "c2", Names: []string{"/firefox-old"}, Image: "firefox:latest", ImageID: "sha256:i1", State: "exited",
+			Mounts: []types.MountPoint{{Type: "volume", Name: "v1"}}},
+		{ID: "c3", Names: []string{"/chrome"}, Image: "chrome:latest", ImageID: "sha256:i3", State: "exited"},
+	}
+	docker.images = []types.ImageSummary{
+		{ID: "sha256:i1", RepoTags: []string{"firefox:latest"}},
+		{ID: "sha256:i2", RepoTags: []string{"<none>:<none>"}},
+	}
+	docker.networks = []types.NetworkResource{
+		{ID: "n1", Name: "ci", Driver: "bridge"},
+	}
+	docker.volumes = []*types.Volume{
+		{Name: "v1", Driver: "local"},
+	}
+	return docker
+}
+
+func TestE2EFilter(t *testing.T) {
+	docker := newE2EDocker(t)
+	defer docker.close()
+
+	result := runCLI(t, docker, "--containers", `.State == "exited" and (.Image | contains("firefox"))`)
+	require.Equal(t, 0, result.code, result.stderr)
+	require.Equal(t, []string{"DELETE /containers/c2"}, docker.mutations())
+	require.Equal(t, []string{"c1", "c3"}, docker.containerIDs())
+	// volumes are only removed with --container.remove.volumes
+	require.Equal(t, []string{"v1"}, docker.volumeNames())
+
+	result = runCLI(t, docker, "--images", `.RepoTags == ["<none>:<none>"]`)
+	require.Equal(t, 0, result.code, result.stderr)
+	require.Equal(t, []string{"DELETE /containers/c2", "DELETE /images/sha256:i2?noprune=1"}, docker.mutations())
+
+	result = runCLI(t, docker, "list", "--networks", "--format", "table")
+	require.Equal(t, 0, result.code, result.stderr)
+	require.Contains(t, result.stdout, "network  n1  ci")
+}
+
+func TestE2EDry(t *testing.T) {
+	docker := newE2EDocker(t)
+	defer docker.close()
+
+	result := runCLI(t, docker, "--dry", "--force", "--all")
+	require.Equal(t, 0, result.code, result.stderr)
+	require.Empty(t, docker.mutations())
+	require.Contains(t, result.stdout, "Dry mode on")
+	for _, line := range []string{
+		"Would delete container c1",
+		"Would delete container c3",
+		"Would delete image sha256:i2",
+		"Would delete network n1",
+	} {
+		require.Contains(t, result.stdout, line)
+	}
+}
+
+func TestE2EStopKill(t *testing.T) {
+	docker := newE2EDocker(t)
+	defer docker.close()
+
+	// running containers cannot be removed without stopping them first
+	result := runCLI(t, docker, "--containers", `.State == "running"`)
+	require.Equal(t, 0, result.code)
+	require.Contains(t, result.stderr, "unable to delete container c1")
+	require.Equal(t, []string{"c1", "c2", "c3"}, docker.containerIDs())
+
+	result = runCLI(t, docker, "--containers", "--container.kill", "SIGTERM", "--container.stop", `.State == "running"`)
+	require.Equal(t, 0, result.code, result.stderr)
+	require.Equal(t, []string{
+		"DELETE /containers/c1",
+		"POST /containers/c1/kill?signal=SIGTERM",
+		"POST /containers/c1/stop",
+		"DELETE /containers/c1",
+	}, docker.mutations())
+
+	// --force kills with 9, stops and forces the removal, --all removes the volumes too
+	docker = newE2EDocker(t)
+	defer docker.close()
+	result = runCLI(t, docker, "--containers", "--force", "--all", `.Image | contains("firefox")`)
+	require.Equal(t, 0, result.code, result.stderr)
+	require.Equal(t, []string{
+		"POST /containers/c1/kill?signal=9",
+		"POST /containers/c1/stop",
+		"DELETE /containers/c1?force=1&link=1&v=1",
+		"DELETE /containers/c2?force=1&link=1&v=1",
+	}, docker.mutations())
+	require.Empty(t, docker.volumeNames())
+}
+
+func TestE2EErrors(t *testing.T) {
+	docker := newE2EDocker(t)
+	defer docker.close()
+
+	result := runCLI(t, docker, ".IsContainer ==")
+	require.Equal(t, 1, result.code)
+	require.Contains(t, result.stderr, "Invalid filter")
+	// the line numbers are those of the filter, not of the filter with the helpers
+	require.Contains(t, result.stderr, "at <top-level>, line 1:")
+	require.Empty(t, docker.mutations())
+
+	// unknown fields are most likely typos
+	result = runCLI(t, docker, "--dry", `.Stat == "exited"`)
+	require.Equal(t, 0, result.code)
+	require.Contains(t, result.stderr, "warning: unknown field .Stat in filter")
+
+	// an image in use fails, the other entities are purged anyway
+	result = runCLI(t, docker, "--images", `.IsImage == true`)
+	require.Equal(t, 0, result.code)
+	require.Contains(t, result.stderr, "image is being used by container c1")
+	require.Equal(t, []string{"DELETE /images/sha256:i1?noprune=1", "DELETE /images/sha256:i2?noprune=1"}, docker.mutations())
+
+	docker.failures["DELETE /networks/n1"] = 500
+	result = runCLI(t, docker, "--networks")
+	require.Equal(t, 0, result.code)
+	require.Contains(t, result.stderr, "unable to delete network n1: Error response from daemon: injected failure")
+
+	// listing errors abort the purge
+	docker.failures["GET /containers/json"] = 500
+	result = runCLI(t, docker, "--containers")
+	require.Equal(t, 1, result.code)
+	require.Contains(t, result.stderr, "injected failure")
+
+	docker.close()
+	result = runCLI(t, docker, "--containers")
+	require.Equal(t, 1, result.code)
+	require.Contains(t, result.stderr, "Cannot connect to the Docker daemon")
+}
+
+func TestE2ECommandFlags(t *testing.T) {
+	docker := newE2EDocker(t)
+	defer docker.close()
+
+	// flags are only accepted by the commands that use them
+	for _, args := range [][]string{
+		{"version", "--force"},
+		{"list", "--budget", "1GB"},
+		{"--force", "version"},
+		{"quota", `.IsContainer`},
+	} {
+		result := runCLI(t, docker, args...)
+		require.Equal(t, 1, result.code, "%v", args)
+	}
+
+	result := runCLI(t, docker, "budget", "1GB", "--dry")
+	require.Equal(t, 0, result.code, result.stderr)
+
+	// the deprecated flags run the commands
+	result = runCLI(t, docker, "--budget", "1GB", "--dry")
+	require.Equal(t, 0, result.code, result.stderr)
+	require.Contains(t, result.stderr, "warning: --budget is deprecated, use `docker-purge budget` instead")
+	require.Empty(t, docker.mutations())
+}
+
+func TestE2EMultiHost(t *testing.T) {
+	docker := newE2EDocker(t)
+	defer docker.close()
+	dir, removeDir := newTempDir(t)
+	defer removeDir()
+
+	// the hosts would share the state, the trash, the archive and the container records
+	for _, args := range [][]string{
+		{"--state-file", filepath.Join(dir, "state.json")},
+		{"--trash"},
+		{"--archive-dir", dir},
+		{"--container.record-dir", dir},
+	} {
+		args = append([]string{"-H", docker.host(), "-H", "tcp://127.0.0.1:1", "--dry"}, args...)
+		result := runCLI(t, docker, args...)
+		require.Equal(t, 1, result.code, "%v", args)
+		require.Contains(t, result.stderr, "can only be used with a single host", "%v", args)
+	}
+	require.Empty(t, docker.mutations())
+}
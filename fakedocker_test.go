@@ -0,0 +1,531 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	networktypes "github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/versions"
+	volumetypes "github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDocker is an in memory docker daemon for end to end tests,
+// it serves the configured entities over the engine api and records every call that changes something
+type fakeDocker struct {
+	mu         sync.Mutex
+	containers []types.Container
+	images     []types.ImageSummary
+	networks   []types.NetworkResource
+	volumes    []*types.Volume
+	// details are returned by container inspect instead of the details derived from the container
+	details map[string]types.ContainerJSON
+	// apiVersion is the version the daemon reports, 1.25 if empty
+	apiVersion string
+	// saved are the refs of every docker save, loaded the tarballs of every docker load
+	saved  []string
+	loaded []string
+	// created are the requests of every container create
+	created []fakeContainerCreate
+	// failures maps a call like "DELETE /images/sha256:i1" to the status code it fails with
+	failures map[string]int
+	calls    []string
+	server   *httptest.Server
+	// events are streamed to the clients subscribed to /events
+	events chan events.Message
+}
+
+// fakeContainerCreate is the body of a container create request
+type fakeContainerCreate struct {
+	containertypes.Config
+	HostConfig       containertypes.HostConfig
+	NetworkingConfig networktypes.NetworkingConfig
+}
+
+var fakeDockerVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+// newFakeDocker starts a fake docker daemon, the entities can be configured until the first request
+func newFakeDocker(t *testing.T) *fakeDocker {
+	f := &fakeDocker{failures: make(map[string]int), details: make(map[string]types.ContainerJSON), events: make(chan events.Message)}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	return f
+}
+
+// host returns the address of the daemon in the DOCKER_HOST format
+func (f *fakeDocker) host() string {
+	return "tcp://" + f.server.Listener.Addr().String()
+}
+
+// client returns a client of the daemon that speaks api 1.25
+func (f *fakeDocker) client(t *testing.T) *client.Client {
+	dockerClient, err := client.NewClient(f.host(), "1.25", nil, nil)
+	require.Nil(t, err, "Expected no Error")
+	return dockerClient
+}
+
+func (f *fakeDocker) close() {
+	f.server.Close()
+}
+
+// mutations returns the calls that changed something, including the failed ones,
+// in the format "METHOD /path?query" without the api version
+func (f *fakeDocker) mutations() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+func (f *fakeDocker) containerIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for _, c := range f.containers {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+func (f *fakeDocker) volumeNames() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for _, v := range f.volumes {
+		names = append(names, v.Name)
+	}
+	return names
+}
+
+// update changes the entities of the daemon while it is running
+func (f *fakeDocker) update(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn()
+}
+
+// emit sends an event to the subscribed client, it blocks until the client received it
+func (f *fakeDocker) emit(msg events.Message) {
+	f.events <- msg
+}
+
+func (f *fakeDocker) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := fakeDockerVersionPrefix.ReplaceAllString(r.URL.Path, "")
+	if r.Method == http.MethodGet && path == "/events" {
+		// streams must not block the other requests
+		f.streamEvents(w, r)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		call := r.Method + " " + path
+		if r.URL.RawQuery != "" {
+			call += "?" + r.URL.RawQuery
+		}
+		f.calls = append(f.calls, call)
+	}
+	if status, ok := f.failures[r.Method+" "+path]; ok {
+		fakeDockerError(w, status, "injected failure")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case path == "/_ping":
+		w.Header().Set("API-Version", f.version())
+		w.Write([]byte("OK"))
+	case r.Method == http.MethodGet && path == "/containers/json":
+		f.listContainers(w, r)
+	case r.Method == http.MethodPost && path == "/containers/create":
+		f.createContainer(w, r)
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[0] == "containers" && parts[2] == "json":
+		f.inspectContainer(w, parts[1])
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "containers" && (parts[2] == "kill" || parts[2] == "stop"):
+		f.stopContainer(w, parts[1])
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "containers" && parts[2] == "start":
+		f.startContainer(w, parts[1])
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "containers" && parts[2] == "rename":
+		f.renameContainer(w, parts[1], r.URL.Query().Get("name"))
+	case r.Method == http.MethodDelete && len(parts) == 2 && parts[0] == "containers":
+		f.removeContainer(w, r, parts[1])
+	case r.Method == http.MethodPost && path == "/commit":
+		f.commitContainer(w, r)
+	case r.Method == http.MethodGet && path == "/images/json":
+		fakeDockerJSON(w, f.images)
+	case r.Method == http.MethodGet && path == "/images/get":
+		f.saved = append(f.saved, r.URL.Query()["names"]...)
+		w.Write([]byte("tarball of " + strings.Join(r.URL.Query()["names"], ",")))
+	case r.Method == http.MethodPost && path == "/images/load":
+		buf, _ := ioutil.ReadAll(r.Body)
+		f.loaded = append(f.loaded, string(buf))
+		fakeDockerJSON(w, map[string]string{"stream": "Loaded image"})
+	case r.Method == http.MethodGet && len(parts) >= 3 && parts[0] == "images" && parts[len(parts)-1] == "json":
+		f.inspectImage(w, strings.Join(parts[1:len(parts)-1], "/"))
+	case r.Method == http.MethodPost && len(parts) >= 3 && parts[0] == "images" && parts[len(parts)-1] == "tag":
+		f.tagImage(w, strings.Join(parts[1:len(parts)-1], "/"), r.URL.Query().Get("repo")+":"+r.URL.Query().Get("tag"))
+	case r.Method == http.MethodDelete && len(parts) >= 2 && parts[0] == "images":
+		f.removeImage(w, r, strings.Join(parts[1:], "/"))
+	case r.Method == http.MethodGet && path == "/networks":
+		f.listNetworks(w, r)
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "networks" && parts[2] == "connect":
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodDelete && len(parts) == 2 && parts[0] == "networks":
+		f.removeNetwork(w, parts[1])
+	case r.Method == http.MethodGet && path == "/system/df" && !versions.LessThan(f.version(), "1.25"):
+		f.diskUsage(w)
+	case r.Method == http.MethodGet && path == "/volumes":
+		fakeDockerJSON(w, volumetypes.VolumesListOKBody{Volumes: f.volumes, Warnings: []string{}})
+	case r.Method == http.MethodDelete && len(parts) == 2 && parts[0] == "volumes":
+		f.removeVolume(w, parts[1])
+	default:
+		fakeDockerError(w, http.StatusNotFound, fmt.Sprintf("page not found: %s %s", r.Method, path))
+	}
+}
+
+func (f *fakeDocker) version() string {
+	if f.apiVersion == "" {
+		return "1.25"
+	}
+	return f.apiVersion
+}
+
+func (f *fakeDocker) streamEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg := <-f.events:
+			enc.Encode(msg)
+			w.(http.Flusher).Flush()
+		}
+	}
+}
+
+// listFilters returns the filters of a list request
+func listFilters(w http.ResponseWriter, r *http.Request) (filters.Args, bool) {
+	args, err := filters.FromParam(r.URL.Query().Get("filters"))
+	if err != nil {
+		fakeDockerError(w, http.StatusBadRequest, err.Error())
+		return args, false
+	}
+	return args, true
+}
+
+// listContainers supports the id, ancestor and network filters,
+// the sizes are only reported if they are requested
+func (f *fakeDocker) listContainers(w http.ResponseWriter, r *http.Request) {
+	args, ok := listFilters(w, r)
+	if !ok {
+		return
+	}
+	containers := []types.Container{}
+	for _, c := range f.containers {
+		if r.URL.Query().Get("size") != "1" {
+			c.SizeRw, c.SizeRootFs = 0, 0
+		}
+		if args.Include("id") && !args.ExactMatch("id", c.ID) {
+			continue
+		}
+		if args.Include("ancestor") && !args.ExactMatch("ancestor", c.ImageID) {
+			continue
+		}
+		if args.Include("network") {
+			connected := false
+			if c.NetworkSettings != nil {
+				for _, endpoint := range c.NetworkSettings.Networks {
+					connected = connected || args.ExactMatch("network", endpoint.NetworkID)
+				}
+			}
+			if !connected {
+				continue
+			}
+		}
+		containers = append(containers, c)
+	}
+	fakeDockerJSON(w, containers)
+}
+
+// listNetworks supports the id filter
+func (f *fakeDocker) listNetworks(w http.ResponseWriter, r *http.Request) {
+	args, ok := listFilters(w, r)
+	if !ok {
+		return
+	}
+	networks := []types.NetworkResource{}
+	for _, n := range f.networks {
+		if args.Include("id") && !args.ExactMatch("id", n.ID) {
+			continue
+		}
+		networks = append(networks, n)
+	}
+	fakeDockerJSON(w, networks)
+}
+
+// image returns the image with the id, id prefix or tag, a tag without version means latest
+func (f *fakeDocker) image(ref string) *types.ImageSummary {
+	for i := range f.images {
+		image := &f.images[i]
+		if image.ID == ref || strings.HasPrefix(strings.TrimPrefix(image.ID, "sha256:"), ref) ||
+			containsString(image.RepoTags, ref) || containsString(image.RepoTags, ref+":latest") {
+			return image
+		}
+	}
+	return nil
+}
+
+// tagImage adds the tag to the image, a tag of another image is moved and a dangling image is not dangling anymore
+func (f *fakeDocker) tagImage(w http.ResponseWriter, ref, tag string) {
+	image := f.image(ref)
+	if image == nil {
+		fakeDockerError(w, http.StatusNotFound, "No such image: "+ref)
+		return
+	}
+	for i := range f.images {
+		f.images[i].RepoTags = removeString(f.images[i].RepoTags, tag)
+	}
+	image.RepoTags = append(removeString(image.RepoTags, "<none>:<none>"), tag)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (f *fakeDocker) inspectImage(w http.ResponseWriter, ref string) {
+	image := f.image(ref)
+	if image == nil {
+		fakeDockerError(w, http.StatusNotFound, "No such image: "+ref)
+		return
+	}
+	fakeDockerJSON(w, types.ImageInspect{ID: image.ID, RepoTags: image.RepoTags, Created: time.Unix(image.Created, 0).Format(time.RFC3339Nano), Size: image.Size})
+}
+
+// diskUsage reports the Size of the images as layer size and the SizeRw of the containers
+func (f *fakeDocker) diskUsage(w http.ResponseWriter) {
+	du := types.DiskUsage{Volumes: f.volumes}
+	for i := range f.images {
+		du.LayersSize += f.images[i].Size
+		du.Images = append(du.Images, &f.images[i])
+	}
+	for i := range f.containers {
+		du.Containers = append(du.Containers, &f.containers[i])
+	}
+	fakeDockerJSON(w, du)
+}
+
+func (f *fakeDocker) container(id string) *types.Container {
+	for i := range f.containers {
+		if f.containers[i].ID == id {
+			return &f.containers[i]
+		}
+	}
+	return nil
+}
+
+func (f *fakeDocker) inspectContainer(w http.ResponseWriter, id string) {
+	c := f.container(id)
+	if c == nil {
+		fakeDockerError(w, http.StatusNotFound, "No such container: "+id)
+		return
+	}
+	if details, ok := f.details[id]; ok {
+		fakeDockerJSON(w, details)
+		return
+	}
+	var details types.ContainerJSON
+	details.ContainerJSONBase = &types.ContainerJSONBase{
+		ID:    c.ID,
+		Image: c.ImageID,
+		State: &types.ContainerState{Status: c.State, Running: c.State == "running"},
+	}
+	if len(c.Names) > 0 {
+		details.Name = c.Names[0]
+	}
+	details.Mounts = c.Mounts
+	fakeDockerJSON(w, details)
+}
+
+// stopContainer handles kill and stop, both leave the container exited
+func (f *fakeDocker) stopContainer(w http.ResponseWriter, id string) {
+	c := f.container(id)
+	if c == nil {
+		fakeDockerError(w, http.StatusNotFound, "No such container: "+id)
+		return
+	}
+	c.State = "exited"
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeDocker) startContainer(w http.ResponseWriter, id string) {
+	c := f.container(id)
+	if c == nil {
+		fakeDockerError(w, http.StatusNotFound, "No such container: "+id)
+		return
+	}
+	c.State = "running"
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeDocker) renameContainer(w http.ResponseWriter, id, name string) {
+	c := f.container(id)
+	if c == nil {
+		fakeDockerError(w, http.StatusNotFound, "No such container: "+id)
+		return
+	}
+	c.Names = []string{"/" + name}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createContainer records the request and adds a container with the id created-N
+func (f *fakeDocker) createContainer(w http.ResponseWriter, r *http.Request) {
+	var create fakeContainerCreate
+	if err := json.NewDecoder(r.Body).Decode(&create); err != nil {
+		fakeDockerError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	f.created = append(f.created, create)
+	id := fmt.Sprintf("created-%d", len(f.created))
+	f.containers = append(f.containers, types.Container{ID: id, Names: []string{"/" + r.URL.Query().Get("name")}, Image: create.Image, State: "created"})
+	fakeDockerJSON(w, containertypes.ContainerCreateCreatedBody{ID: id})
+}
+
+// commitContainer adds an image with the id sha256:committed-CONTAINER
+func (f *fakeDocker) commitContainer(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if f.container(query.Get("container")) == nil {
+		fakeDockerError(w, http.StatusNotFound, "No such container: "+query.Get("container"))
+		return
+	}
+	image := types.ImageSummary{ID: "sha256:committed-" + query.Get("container")}
+	if query.Get("repo") != "" {
+		image.RepoTags = []string{query.Get("repo") + ":" + query.Get("tag")}
+	}
+	f.images = append(f.images, image)
+	fakeDockerJSON(w, types.IDResponse{ID: image.ID})
+}
+
+func (f *fakeDocker) removeContainer(w http.ResponseWriter, r *http.Request, id string) {
+	c := f.container(id)
+	if c == nil {
+		fakeDockerError(w, http.StatusNotFound, "No such container: "+id)
+		return
+	}
+	if c.State == "running" && r.URL.Query().Get("force") != "1" {
+		fakeDockerError(w, http.StatusConflict, fmt.Sprintf("You cannot remove a running container %s. Stop the container before attempting removal or use -f", id))
+		return
+	}
+	if r.URL.Query().Get("v") == "1" {
+		for _, m := range c.Mounts {
+			f.deleteVolume(m.Name)
+		}
+	}
+	for i := range f.containers {
+		if f.containers[i].ID == id {
+			f.containers = append(f.containers[:i], f.containers[i+1:]...)
+			break
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeImage untags the image if it is referenced by one of several tags or its last tag is removed
+// while a container uses it, it deletes the image otherwise
+func (f *fakeDocker) removeImage(w http.ResponseWriter, r *http.Request, ref string) {
+	id := ref
+	if image := f.image(ref); image != nil && containsString(image.RepoTags, ref) {
+		used := false
+		for _, c := range f.containers {
+			used = used || c.ImageID == image.ID
+		}
+		if len(image.RepoTags) > 1 || used {
+			image.RepoTags = removeString(image.RepoTags, ref)
+			if len(image.RepoTags) == 0 {
+				image.RepoTags = []string{"<none>:<none>"}
+			}
+			fakeDockerJSON(w, []types.ImageDelete{{Untagged: ref}})
+			return
+		}
+		id = image.ID
+	}
+	for i, image := range f.images {
+		if image.ID != id {
+			continue
+		}
+		if r.URL.Query().Get("force") != "1" {
+			for _, c := range f.containers {
+				if c.ImageID == id {
+					fakeDockerError(w, http.StatusConflict, fmt.Sprintf("conflict: unable to delete %s - image is being used by container %s", id, c.ID))
+					return
+				}
+			}
+		}
+		f.images = append(f.images[:i], f.images[i+1:]...)
+		fakeDockerJSON(w, []types.ImageDelete{{Deleted: id}})
+		return
+	}
+	fakeDockerError(w, http.StatusNotFound, "No such image: "+id)
+}
+
+func (f *fakeDocker) removeNetwork(w http.ResponseWriter, id string) {
+	for i, n := range f.networks {
+		if n.ID == id {
+			f.networks = append(f.networks[:i], f.networks[i+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	fakeDockerError(w, http.StatusNotFound, "network "+id+" not found")
+}
+
+func (f *fakeDocker) removeVolume(w http.ResponseWriter, name string) {
+	if !f.deleteVolume(name) {
+		fakeDockerError(w, http.StatusNotFound, "no such volume: "+name)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeDocker) deleteVolume(name string) bool {
+	for i, v := range f.volumes {
+		if v.Name == name {
+			f.volumes = append(f.volumes[:i], f.volumes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, s string) []string {
+	var result []string
+	for _, item := range list {
+		if item != s {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func fakeDockerJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func fakeDockerError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"time"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// commandFlag is a flag that is defined on several commands, the commands share its value
+type commandFlag []*kingpin.FlagClause
+
+// flagOn defines the flag on every command
+func flagOn(commands []*kingpin.CmdClause, name, help string) commandFlag {
+	f := make(commandFlag, len(commands))
+	for i, command := range commands {
+		f[i] = command.Flag(name, help)
+	}
+	return f
+}
+
+func (f commandFlag) Short(name rune) commandFlag {
+	for _, clause := range f {
+		clause.Short(name)
+	}
+	return f
+}
+
+func (f commandFlag) Default(values ...string) commandFlag {
+	for _, clause := range f {
+		clause.Default(values...)
+	}
+	return f
+}
+
+func (f commandFlag) Bool() *bool {
+	target := new(bool)
+	for _, clause := range f {
+		clause.BoolVar(target)
+	}
+	return target
+}
+
+func (f commandFlag) String() *string {
+	target := new(string)
+	for _, clause := range f {
+		clause.StringVar(target)
+	}
+	return target
+}
+
+func (f commandFlag) Strings() *[]string {
+	target := new([]string)
+	for _, clause := range f {
+		clause.StringsVar(target)
+	}
+	return target
+}
+
+func (f commandFlag) Int() *int {
+	target := new(int)
+	for _, clause := range f {
+		clause.IntVar(target)
+	}
+	return target
+}
+
+func (f commandFlag) Duration() *time.Duration {
+	target := new(time.Duration)
+	for _, clause := range f {
+		clause.DurationVar(target)
+	}
+	return target
+}
+
+func (f commandFlag) Enum(options ...string) *string {
+	target := new(string)
+	for _, clause := range f {
+		clause.EnumVar(target, options...)
+	}
+	return target
+}
+
+func (f commandFlag) ExistingFile() *string {
+	target := new(string)
+	for _, clause := range f {
+		clause.ExistingFileVar(target)
+	}
+	return target
+}
@@ -0,0 +1,11 @@
+package main
+
+// containsString reports whether s is in list
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
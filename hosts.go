@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+)
+
+// dockerHost is a docker daemon to purge
+type dockerHost struct {
+	// Name is used in the output, defaults to Host or Context
+	Name string `json:"name"`
+	Host string `json:"host"`
+	// Context is the docker cli context to use instead of Host and the tls settings
+	Context   string `json:"context"`
+	TLSCACert string `json:"tlscacert"`
+	TLSCert   string `json:"tlscert"`
+	TLSKey    string `json:"tlskey"`
+	TLS       bool   `json:"tls"`
+	TLSVerify bool   `json:"tlsverify"`
+}
+
+// inventory lists the docker hosts to purge
+type inventory struct {
+	Hosts []*dockerHost `json:"hosts"`
+}
+
+func loadInventory(path string) ([]*dockerHost, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var inv inventory
+	if err := json.Unmarshal(buf, &inv); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %s", path, err.Error())
+	}
+	for i, h := range inv.Hosts {
+		if h.Host == "" && h.Context == "" {
+			return nil, fmt.Errorf("%s: host %d has neither host nor context", path, i+1)
+		}
+		if h.Context != "" {
+			ctx, err := loadContext(dockerConfigDir(), h.Context)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", path, err.Error())
+			}
+			if h.Name == "" {
+				h.Name = h.Context
+			}
+			h.Host, h.TLSCACert, h.TLSCert, h.TLSKey, h.TLS, h.TLSVerify = ctx.Host, ctx.TLSCACert, ctx.TLSCert, ctx.TLSKey, ctx.TLS, ctx.TLSVerify
+		}
+	}
+	return inv.Hosts, nil
+}
+
+// flagHosts returns the hosts specified by --host, --context and --inventory, names must be unique.
+// Without any of them the host of the environment or the current docker context is returned,
+// no host is returned if the docker client can handle the environment itself.
+func flagHosts() ([]*dockerHost, error) {
+	var hosts []*dockerHost
+	for _, host := range *hostFlag {
+		h := &dockerHost{
+			Host:      host,
+			TLSCACert: *tlsCACertFlag,
+			TLSCert:   *tlsCertFlag,
+			TLSKey:    *tlsKeyFlag,
+			TLS:       *tlsFlag,
+			TLSVerify: *tlsVerifyFlag || os.Getenv("DOCKER_TLS_VERIFY") != "",
+		}
+		if h.TLS || h.TLSVerify {
+			defaultTLSFiles(h)
+		}
+		hosts = append(hosts, h)
+	}
+	for _, name := range *contextFlag {
+		if name == defaultContext {
+			if len(*contextFlag) > 1 || len(*hostFlag) > 0 || *inventoryFlag != "" {
+				return nil, fmt.Errorf("the default context cannot be combined with other hosts, use DOCKER_HOST instead")
+			}
+			if h := envDockerHost(); h != nil {
+				return []*dockerHost{h}, nil
+			}
+			return nil, nil
+		}
+		h, err := loadContext(dockerConfigDir(), name)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, h)
+	}
+	if *inventoryFlag != "" {
+		inv, err := loadInventory(*inventoryFlag)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, inv...)
+	}
+	if len(hosts) == 0 {
+		h, err := envHost()
+		if err != nil || h == nil {
+			return nil, err
+		}
+		hosts = append(hosts, h)
+	}
+
+	names := make(map[string]bool)
+	for _, h := range hosts {
+		if h.Name == "" {
+			h.Name = h.Host
+		}
+		if names[h.Name] {
+			return nil, fmt.Errorf("duplicate host `%s'", h.Name)
+		}
+		names[h.Name] = true
+	}
+	return hosts, nil
+}
+
+// clientHost returns the docker host the client of a single host connects to,
+// the environment is used without a host
+func clientHost(hosts []*dockerHost) string {
+	if len(hosts) == 1 {
+		return hosts[0].Host
+	}
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		return host
+	}
+	return client.DefaultDockerHost
+}
+
+// defaultTLSFiles uses the tls files in the docker config dir, like the docker cli does,
+// the ca is only used to verify the host
+func defaultTLSFiles(h *dockerHost) {
+	for path, file := range map[*string]string{&h.TLSCACert: "ca.pem", &h.TLSCert: "cert.pem", &h.TLSKey: "key.pem"} {
+		if path == &h.TLSCACert && !h.TLSVerify {
+			continue
+		}
+		if *path == "" {
+			if _, err := os.Stat(filepath.Join(dockerConfigDir(), file)); err == nil {
+				*path = filepath.Join(dockerConfigDir(), file)
+			}
+		}
+	}
+}
+
+// newClient connects to the host, ssh hosts are tunnelled through `docker system dial-stdio`
+func (h *dockerHost) newClient() (*client.Client, error) {
+	if isSSHHost(h.Host) {
+		if _, err := sshCommand(h.Host); err != nil {
+			return nil, err
+		}
+		// the address is never dialed, all connections go through ssh
+		return client.NewClient("tcp://docker", client.DefaultVersion, sshHTTPClient(h.Host), nil)
+	}
+	var httpClient *http.Client
+	// like the docker cli only --tls and --tlsverify enable tls, a ca is never ignored
+	// and the system roots are used without one
+	if h.TLS || h.TLSVerify {
+		tlsc, err := tlsconfig.Client(tlsconfig.Options{
+			CAFile:             h.TLSCACert,
+			CertFile:           h.TLSCert,
+			KeyFile:            h.TLSKey,
+			InsecureSkipVerify: !h.TLSVerify && h.TLSCACert == "",
+		})
+		if err != nil {
+			return nil, err
+		}
+		httpClient = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsc}}
+	}
+	return client.NewClient(h.Host, client.DefaultVersion, httpClient, nil)
+}
+
+// checkMultiHost returns an error if the command or flags cannot be used with several hosts
+func checkMultiHost(command string) error {
+	switch {
+	case command != purgeCommand.FullCommand():
+		return fmt.Errorf("%s can only be used with a single host", command)
+	case *stateFileFlag != "" || *trashFlag:
+		return fmt.Errorf("the state file and the trash can only be used with a single host")
+	case *archiveDirFlag != "" || *containerRecordDirFlag != "":
+		// the hosts would write to the same index
+		return fmt.Errorf("the archive and the container records can only be used with a single host")
+	}
+	return nil
+}
+
+// hostReport is the result of purging a host
+type hostReport struct {
+	Host    string        `json:"host"`
+	Summary *purgeSummary `json:"summary,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// purgeHost purges a single host, a failing host never affects the others
+func purgeHost(h *dockerHost, p policy, origin purgeOrigin) (report hostReport) {
+	report.Host = h.Name
+	defer func() {
+		if r := recover(); r != nil {
+			report.Error = fmt.Sprintf("panic: %v", r)
+		}
+	}()
+	dockerClient, err := h.newClient()
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	defer dockerClient.Close()
+	if err := negotiateAPIVersion(dockerClient, *apiVersionFlag); err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	reportAPIFeatures(os.Stderr, h.Name+": ", dockerClient)
+
+	nt := flagNotifier()
+	var used int64
+	if nt != nil {
+		used = nt.usedSpace(dockerClient, p)
+	}
+	start := time.Now()
+	origin.Host = h.Name
+	summary, err := runPurge(dockerClient, p, origin)
+	origin.metrics.observeRun("cli", p, summary, err, time.Since(start))
+	if nt != nil {
+		nt.notify(newNotification(h.Name, "cli", p, start, summary, err, used, nt.usedSpace(dockerClient, p)))
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	report.Summary = summary
+	return report
+}
+
+// purgeHosts purges all hosts concurrently, at most parallel at a time
+func purgeHosts(hosts []*dockerHost, p policy, origin purgeOrigin, parallel int) []hostReport {
+	if parallel < 1 {
+		parallel = 1
+	}
+	reports := make([]hostReport, len(hosts))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, h := range hosts {
+		wg.Add(1)
+		go func(i int, h *dockerHost) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			reports[i] = purgeHost(h, p, origin)
+		}(i, h)
+	}
+	wg.Wait()
+	return reports
+}
+
+func handleHostsPurge(hosts []*dockerHost, origin purgeOrigin) {
+	p := flagPolicy()
+	if p.Dry {
+		fmt.Fprintln(os.Stdout, "Dry mode on")
+	}
+	origin.Rule = "cli"
+	reports := purgeHosts(hosts, p, origin, *parallelFlag)
+
+	failed := false
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tCONTAINERS\tIMAGES\tNETWORKS\tFAILED\tERROR")
+	var total purgeSummary
+	for _, r := range reports {
+		var s purgeSummary
+		if r.Summary != nil {
+			s = *r.Summary
+		}
+		if r.Error != "" || s.Failed > 0 {
+			failed = true
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n", r.Host, s.Containers, s.Images, s.Networks, s.Failed, r.Error)
+		total.Containers += s.Containers
+		total.Images += s.Images
+		total.Networks += s.Networks
+		total.Failed += s.Failed
+	}
+	fmt.Fprintf(w, "total\t%d\t%d\t%d\t%d\t\n", total.Containers, total.Images, total.Networks, total.Failed)
+	w.Flush()
+
+	if *reportFlag != "" {
+		buf, err := json.MarshalIndent(reports, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(*reportFlag, buf, 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to write report: %s\n", err.Error())
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeHosts(t *testing.T) {
+	docker := newE2EDocker(t)
+	defer docker.close()
+
+	hosts := []*dockerHost{
+		{Name: "build-1", Host: docker.host()},
+		// nothing listens on port 1, the host must fail without affecting the other
+		{Name: "build-2", Host: "tcp://127.0.0.1:1"},
+		{Name: "build-3", Host: "invalid://host"},
+	}
+	p := policy{Filter: `.State == "exited"`, Containers: true}
+	reports := purgeHosts(hosts, p, purgeOrigin{Rule: "cli"}, 2)
+	require.Len(t, reports, 3)
+
+	require.Equal(t, "build-1", reports[0].Host)
+	require.Empty(t, reports[0].Error)
+	require.Equal(t, 2, reports[0].Summary.Containers)
+	require.Equal(t, []string{"DELETE /containers/c2", "DELETE /containers/c3"}, docker.mutations())
+
+	require.Equal(t, "build-2", reports[1].Host)
+	require.NotEmpty(t, reports[1].Error)
+	require.Equal(t, "build-3", reports[2].Host)
+	require.NotEmpty(t, reports[2].Error)
+}
+
+func TestNewClientTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fakeDockerJSON(w, map[string]string{"Version": "1.13.1"})
+	}))
+	defer server.Close()
+	host := strings.Replace(server.URL, "https://", "tcp://", 1)
+
+	// --tls uses TLS without verifying the certificate of the host
+	dockerClient, err := (&dockerHost{Host: host, TLS: true}).newClient()
+	require.Nil(t, err, "Expected no Error")
+	_, err = dockerClient.ServerVersion(context.Background())
+	require.Nil(t, err, "Expected no Error")
+
+	dockerClient, err = (&dockerHost{Host: host, TLSVerify: true}).newClient()
+	require.Nil(t, err, "Expected no Error")
+	_, err = dockerClient.ServerVersion(context.Background())
+	require.NotNil(t, err)
+
+	dir, removeDir := newTempDir(t)
+	defer removeDir()
+	ca := filepath.Join(dir, "ca.pem")
+	require.Nil(t, ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+	dockerClient, err = (&dockerHost{Host: host, TLSVerify: true, TLSCACert: ca}).newClient()
+	require.Nil(t, err, "Expected no Error")
+	_, err = dockerClient.ServerVersion(context.Background())
+	require.Nil(t, err, "Expected no Error")
+
+	// a ca is never ignored, even without --tlsverify
+	invalid := filepath.Join(dir, "invalid.pem")
+	require.Nil(t, ioutil.WriteFile(invalid, []byte("invalid"), 0600))
+	_, err = (&dockerHost{Host: host, TLS: true, TLSCACert: invalid}).newClient()
+	require.NotNil(t, err)
+
+	// the tls files alone do not enable tls
+	dockerClient, err = (&dockerHost{Host: host, TLSCACert: ca}).newClient()
+	require.Nil(t, err, "Expected no Error")
+	_, err = dockerClient.ServerVersion(context.Background())
+	require.NotNil(t, err)
+}
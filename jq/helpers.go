@@ -0,0 +1,49 @@
+package jq
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Now is the time the helpers compare with, it is evaluated once per filter
+var Now = time.Now
+
+// helpers are defined before every filter.
+// durations are numbers of seconds or strings like "90s", "30m", "12h", "7d", "2w",
+// sizes are numbers of bytes or strings like "512B", "10MB", "2GB", "1GiB".
+// name_matches tests the glob against the Name, the container names and the image tags.
+// label_value is named so because label is a jq keyword.
+// semver_lt compares the input version with its argument, pre-releases are compared as strings.
+const helpers = `
+def _number_with_unit($s; $units; $what):
+  if ($s | type) == "number" then $s
+  else (($s | capture("^(?<n>[0-9]+(\\.[0-9]+)?) *(?<u>[A-Za-z]*)$")) // null) as $m
+    | if $m != null and ($units | has($m.u)) then ($m.n | tonumber) * $units[$m.u]
+      else error("invalid \($what): \($s)") end
+  end;
+def _duration($d): _number_with_unit($d; {"": 1, "s": 1, "m": 60, "h": 3600, "d": 86400, "w": 604800}; "duration");
+def bytes($s): _number_with_unit($s; {"": 1, "B": 1, "KB": 1000, "MB": 1000000, "GB": 1000000000, "TB": 1000000000000,
+  "KiB": 1024, "MiB": 1048576, "GiB": 1073741824, "TiB": 1099511627776}; "size");
+def older_than($d): _now - .Created > _duration($d);
+def has_label($k): (.Labels // {}) | has($k);
+def label_value($k): (.Labels // {})[$k];
+def _glob_regex: gsub("(?<c>[.+^$(){}|\\[\\]\\\\])"; "\\\(.c)") | gsub("\\*"; ".*") | gsub("\\?"; ".") | "^\(.)$";
+def name_matches($glob): ($glob | _glob_regex) as $re
+  | any(.Name, ((.Names // [])[] | ltrimstr("/")), (.RepoTags // [])[]; type == "string" and test($re));
+def dangling: .IsImage == true and ((.RepoTags // []) | all(. == "<none>:<none>"));
+def _semver: ltrimstr("v") | split("+")[0] | (index("-") // length) as $i
+  | [((.[:$i] | split(".") | map(tonumber)) + [0, 0, 0])[:3], .[$i + 1:]];
+def semver_lt($v): _semver as $a | ($v | _semver) as $b
+  | if $a[0] != $b[0] then $a[0] < $b[0] else $a[1] != "" and ($b[1] == "" or $a[1] < $b[1]) end;
+`
+
+// oneLineHelpers keeps the helpers on the first line, so jq prints just the line of the filter on syntax errors,
+// the line numbers jq reports are one higher than in the filter and are corrected in ReportError
+var oneLineHelpers = strings.Replace(strings.TrimSpace(helpers), "\n", " ", -1)
+
+// withHelpers returns the filter with the helpers defined before it,
+// _now is not named now so the builtin of jq keeps working
+func withHelpers(filter string) string {
+	return fmt.Sprintf("def _now: %d; %s\n%s", Now().Unix(), oneLineHelpers, filter)
+}
@@ -4,12 +4,28 @@
 #include "jq.h"
 #include "jv.h"
 
+// ReportError prints the errors like jq does, but with the line numbers of the filter,
+// the helpers take the first line of the program
+static void ReportError(void *data, jv msg) {
+    msg = jq_format_error(msg);
+    const char *s = jv_string_value(msg);
+    const char *line = strstr(s, ", line ");
+    char *end = NULL;
+    long n = line != NULL ? strtol(line + 7, &end, 10) : 0;
+    if (n > 1) {
+        fprintf(stderr, "%.*s, line %ld%s\n", (int)(line - s), s, n - 1, end);
+    } else {
+        fprintf(stderr, "%s\n", s);
+    }
+    jv_free(msg);
+}
 
 static int IsValidFilter(const char *filter) {
     jq_state *jq = jq_init();
     if (jq == NULL) {
         return 0;
     }
+    jq_set_error_cb(jq, ReportError, NULL);
     if (jq_compile(jq, filter) <= 0) {
         return 0;
     }
@@ -35,6 +51,7 @@ static int MatchesFilter(const char *in, const char* filter) {
         result = JQ_INIT_FAILED;
         goto end;
     }
+    jq_set_error_cb(jq, ReportError, NULL);
 
     
     if (!jq_compile(jq, filter)) {
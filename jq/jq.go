@@ -9,18 +9,18 @@ import (
 	"unsafe"
 )
 
-// IsValidFilter checks if an jq filter is valid
+// IsValidFilter checks if an jq filter is valid, the filter can use the helpers
 func IsValidFilter(filter string) bool {
-	f := C.CString(filter)
+	f := C.CString(withHelpers(filter))
 	result := C.IsValidFilter(f)
 	C.free(unsafe.Pointer(f))
 	return result == 1
 }
 
-// MatchesFilter returns if some json data matches a jq filter
+// MatchesFilter returns if some json data matches a jq filter, the filter can use the helpers
 func MatchesFilter(jsonData, filter string) (bool, error) {
 	in := C.CString(jsonData)
-	f := C.CString(filter)
+	f := C.CString(withHelpers(filter))
 
 	result := C.MatchesFilter(in, f)
 	C.free(unsafe.Pointer(in))
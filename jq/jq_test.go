@@ -2,6 +2,7 @@ package jq
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -42,3 +43,57 @@ func TestMatchFilter(t *testing.T) {
 		}
 	}
 }
+
+func TestHelpers(t *testing.T) {
+	Now = func() time.Time { return time.Unix(1500000000, 0) }
+	defer func() { Now = time.Now }()
+
+	container := `{"IsContainer": true, "Name": "firefox-1", "Names": ["/firefox-1", "/web"], "Created": 1499000000,
+		"Labels": {"team": "web", "ci": ""}, "SizeBytes": 3000000000}`
+	image := `{"IsImage": true, "Name": "", "RepoTags": ["<none>:<none>"], "Created": 1499990000}`
+	tests := []struct {
+		Input    string
+		Filter   string
+		Ok       bool
+		HasError bool
+	}{
+		// the builtin now is not affected by Now
+		{container, `now > 1600000000`, true, false},
+		{container, `older_than("7d")`, true, false},
+		{container, `older_than("2w")`, false, false},
+		{image, `older_than("2h")`, true, false},
+		{image, `older_than(36000)`, false, false},
+		// runtime errors do not match
+		{container, `older_than("7 days")`, false, false},
+		{container, `.SizeBytes > bytes("2GB")`, true, false},
+		{container, `.SizeBytes > bytes("3GiB")`, false, false},
+		{container, `bytes("1.5KB") == 1500 and bytes(10) == 10`, true, false},
+		{container, `has_label("ci") and (has_label("env") | not)`, true, false},
+		{image, `has_label("ci")`, false, false},
+		{container, `label_value("team") == "web" and label_value("env") == null`, true, false},
+		{container, `name_matches("fire*")`, true, false},
+		{container, `name_matches("we?")`, true, false},
+		{container, `name_matches("fire")`, false, false},
+		{container, `name_matches("firefox.1")`, false, false},
+		{`{"RepoTags": ["registry.io/firefox:1.0"]}`, `name_matches("registry.io/*:1.?")`, true, false},
+		{image, `dangling`, true, false},
+		{container, `dangling`, false, false},
+		{`{"IsImage": true, "RepoTags": ["firefox:latest"]}`, `dangling`, false, false},
+		{container, `"1.2.3" | semver_lt("1.10.0")`, true, false},
+		{container, `"v2.0" | semver_lt("1.10.0")`, false, false},
+		{container, `"1.0.0-rc1" | semver_lt("1.0.0")`, true, false},
+		{container, `"1.0.0" | semver_lt("1.0.0")`, false, false},
+	}
+	for _, test := range tests {
+		ok, err := MatchesFilter(test.Input, test.Filter)
+		require.Equal(t, test.Ok, ok, test.Filter)
+		if test.HasError {
+			require.NotNil(t, err, "Expected Error")
+		} else {
+			require.Nil(t, err, "Expected no Error")
+		}
+	}
+
+	require.True(t, IsValidFilter(`older_than("7d") and dangling`))
+	require.False(t, IsValidFilter(`older_than(`))
+}
@@ -5,8 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/Eun/docker-purge/jq"
+	"github.com/Eun/docker-purge/purge"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
 
@@ -25,33 +29,164 @@ var Version = "Unknown/CustomBuild"
 var BuildDate = "Unknown/CustomBuild"
 
 var (
-	filterArg = kingpin.Arg("filter", "jq filter to apply").String()
+	purgeCommand = kingpin.Command("purge", "purge docker containers, images and networks").Default()
+
+	// docker cli plugin
+	pluginMetadataCommand = kingpin.Command("docker-cli-plugin-metadata", "print the metadata of the docker cli plugin").Hidden()
+
+	watchCommand        = kingpin.Command("watch", "watch docker events and purge entities as soon as they match the filter")
+	daemonCommand       = kingpin.Command("daemon", "run policies on cron schedules, reloads the config on SIGHUP")
+	serveCommand        = kingpin.Command("serve", "serve an http api to list entities and trigger purges")
+	budgetCommand       = kingpin.Command("budget", "purge least recently used images until the image storage is below the budget")
+	quotaCommand        = kingpin.Command("quota", "purge the oldest entities of every owner that exceeds its quota")
+	listCommand         = kingpin.Command("list", "list the docker containers, images and networks that match the filter")
+	planCommand         = kingpin.Command("plan", "write the entities a purge would delete to a plan that can be reviewed and applied")
+	applyCommand        = kingpin.Command("apply", "delete the entities of a plan")
+	snapshotCommand     = kingpin.Command("snapshot", "save the entities to evaluate filters offline")
+	snapshotSaveCommand = snapshotCommand.Command("save", "save the document of every entity, exactly as the filters see it")
+	emptyTrashCommand   = kingpin.Command("empty-trash", "delete trashed containers and images for good")
+	restoreCommand      = kingpin.Command("restore", "restore trashed containers and images, or load archived images")
+	resurrectCommand    = kingpin.Command("resurrect", "recreate a deleted container from its record")
+	schemaCommand       = kingpin.Command("schema", "print the json schema of the documents the filters operate on")
+	testCommand         = kingpin.Command("test", "run the policies of a directory against their fixtures")
+	versionCommand      = kingpin.Command("version", "print the version of docker-purge")
+)
+
+// the commands that share a flag
+var (
+	// deletingCommands delete entities, they take the remove, archive, record and audit flags
+	deletingCommands = []*kingpin.CmdClause{purgeCommand, watchCommand, daemonCommand, serveCommand, applyCommand, budgetCommand, quotaCommand, emptyTrashCommand}
+	dryCommands      = []*kingpin.CmdClause{purgeCommand, watchCommand, applyCommand, budgetCommand, quotaCommand, emptyTrashCommand, restoreCommand, resurrectCommand}
+	limitCommands    = []*kingpin.CmdClause{purgeCommand, watchCommand, listCommand, planCommand, quotaCommand}
+	trashCommands    = []*kingpin.CmdClause{purgeCommand, watchCommand, applyCommand}
+	stateCommands    = append([]*kingpin.CmdClause{listCommand, planCommand, snapshotSaveCommand, restoreCommand}, deletingCommands...)
+	archiveCommands  = append([]*kingpin.CmdClause{restoreCommand}, deletingCommands...)
+	recordCommands   = append([]*kingpin.CmdClause{resurrectCommand}, deletingCommands...)
+)
+
+var (
+	filterArg = purgeCommand.Arg("filter", "jq filter to apply").String()
+
+	// watch
+	watchGraceFlag  = watchCommand.Flag("grace", "time to wait after an event before the entity is purged").Default("30s").Duration()
+	watchResyncFlag = watchCommand.Flag("resync", "interval of full purge runs, 0 disables them").Default("1h").Duration()
+
+	// disk pressure
+	pressureMinFreeFlag         = watchCommand.Flag("pressure.min-free", "run the emergency filter if the free space of the docker root dir drops below this size or percentage (e.g. 10GB or 10%)").String()
+	pressureMinFreeInodesFlag   = watchCommand.Flag("pressure.min-free-inodes", "run the emergency filter if the free inodes of the docker root dir drop below this number or percentage").String()
+	pressureHighWaterFlag       = watchCommand.Flag("pressure.high-water", "free space to recover to, defaults to pressure.min-free").String()
+	pressureHighWaterInodesFlag = watchCommand.Flag("pressure.high-water-inodes", "free inodes to recover to, defaults to pressure.min-free-inodes").String()
+	pressureIntervalFlag        = watchCommand.Flag("pressure.interval", "interval to check the free space").Default("1m").String()
+	pressurePathFlag            = watchCommand.Flag("pressure.path", "path to check instead of the docker root dir").String()
+	pressureFilterFlag          = watchCommand.Flag("pressure.filter", "jq filter of the emergency purge, required if a threshold is set").String()
+
+	// daemon
+	daemonConfigFlag        = daemonCommand.Flag("config", "config file with the policies to run").Required().ExistingFile()
+	daemonMetricsListenFlag = daemonCommand.Flag("metrics.listen", "address to serve prometheus metrics on").String()
+
+	// serve
+	serveListenFlag = serveCommand.Flag("listen", "address to listen on, listening on other than loopback addresses requires --token").Default("127.0.0.1:8080").String()
+	serveTokenFlag  = serveCommand.Flag("token", "bearer token clients have to send").Envar("DOCKER_PURGE_TOKEN").String()
+
+	// hosts
+	hostFlag       = kingpin.Flag("host", "docker host to connect to, repeat to purge several hosts at once").Short('H').Strings()
+	contextFlag    = kingpin.Flag("context", "docker cli context to use, repeat to purge several contexts at once").Short('c').Strings()
+	tlsCACertFlag  = kingpin.Flag("tlscacert", "trust certs signed only by this CA").String()
+	tlsCertFlag    = kingpin.Flag("tlscert", "path to TLS certificate file").String()
+	tlsKeyFlag     = kingpin.Flag("tlskey", "path to TLS key file").String()
+	tlsFlag        = kingpin.Flag("tls", "use TLS; implied by --tlsverify").Bool()
+	tlsVerifyFlag  = kingpin.Flag("tlsverify", "use TLS and verify the remote").Bool()
+	apiVersionFlag = kingpin.Flag("api-version", "docker api version to use instead of negotiating it with the daemon").String()
+	inventoryFlag  = purgeCommand.Flag("inventory", "json file with the docker hosts to purge").ExistingFile()
+	parallelFlag   = purgeCommand.Flag("parallel", "number of hosts that are purged at the same time").Default("8").Int()
+	reportFlag     = purgeCommand.Flag("report", "write the combined report of a multi host purge as json to this file").String()
+
 	// list
-	listAllFlag       = kingpin.Flag("list-all", "list docker containers, images, networks").Bool()
-	listContainerFlag = kingpin.Flag("list-containers", "list docker containers").Bool()
-	listImageFlag     = kingpin.Flag("list-images", "list docker images").Bool()
-	listNetworkFlag   = kingpin.Flag("list-networks", "list docker networks").Bool()
+	listFormatFlag   = listCommand.Flag("format", "output format: json or table").Default("json").Enum("json", "table")
+	fromSnapshotFlag = flagOn([]*kingpin.CmdClause{listCommand, planCommand}, "from-snapshot", "select the entities from a snapshot instead of the docker daemon").ExistingFile()
+
+	// deprecated list flags, use the list command instead
+	listAllFlag       = purgeCommand.Flag("list-all", "list docker containers, images, networks").Hidden().Bool()
+	listContainerFlag = purgeCommand.Flag("list-containers", "list docker containers").Hidden().Bool()
+	listImageFlag     = purgeCommand.Flag("list-images", "list docker images").Hidden().Bool()
+	listNetworkFlag   = purgeCommand.Flag("list-networks", "list docker networks").Hidden().Bool()
+
+	// plan
+	planOutFlag  = planCommand.Flag("out", "file to write the plan to, defaults to stdout").Short('o').String()
+	applyPlanArg = applyCommand.Arg("plan", "plan file written by plan").Required().ExistingFile()
+
+	// snapshot
+	snapshotSaveOutArg = snapshotSaveCommand.Arg("file", "file to write the snapshot to, defaults to stdout").String()
+
+	// schema
+	schemaKindArg = schemaCommand.Arg("kind", "container, image or network, defaults to all kinds").Enum("container", "image", "network")
+
+	// test
+	testDirArg = testCommand.Arg("dir", "directory with the policies (NAME.json) and their fixtures (NAME.fixtures.json)").Required().ExistingDir()
 
-	dryRunFlag = kingpin.Flag("dry", "dry run, do not purge anything").Short('d').Bool()
+	dryRunFlag = flagOn(dryCommands, "dry", "dry run, do not purge anything").Short('d').Bool()
 
 	// limit
-	limitToContainerFlag = kingpin.Flag("containers", "limit purge to docker containers").Bool()
-	limitToImageFlag     = kingpin.Flag("images", "limit purge to docker images").Bool()
-	limitToNetworkFlag   = kingpin.Flag("networks", "limit purge to docker networks").Bool()
+	limitToContainerFlag = flagOn(limitCommands, "containers", "limit purge to docker containers").Bool()
+	limitToImageFlag     = flagOn(limitCommands, "images", "limit purge to docker images").Bool()
+	limitToNetworkFlag   = flagOn(limitCommands, "networks", "limit purge to docker networks").Bool()
 
-	forceRemoveFlag = kingpin.Flag("force", "sets container.remove.force and image.remove.force to true").Bool()
-	removeAllFlag   = kingpin.Flag("all", "remove everything related to an entity").Bool()
+	forceRemoveFlag = flagOn(deletingCommands, "force", "sets container.remove.force and image.remove.force to true").Bool()
+	removeAllFlag   = flagOn(deletingCommands, "all", "remove everything related to an entity").Bool()
 
 	// container remove options
-	containerRemoveForceFlag   = kingpin.Flag("container.remove.force", "force removal of container").Bool()
-	containerRemoveLinksFlag   = kingpin.Flag("container.remove.links", "remove links during removal").Bool()
-	containerRemoveVolumesFlag = kingpin.Flag("container.remove.volumes", "remove volumes during removal").Bool()
-	containerStop              = kingpin.Flag("container.stop", "stop running docker container").Bool()
-	containerKillSignal        = kingpin.Flag("container.kill", "kill running docker container with the specified signal").Default("").String()
+	containerRemoveForceFlag   = flagOn(deletingCommands, "container.remove.force", "force removal of container").Bool()
+	containerRemoveLinksFlag   = flagOn(deletingCommands, "container.remove.links", "remove links during removal").Bool()
+	containerRemoveVolumesFlag = flagOn(deletingCommands, "container.remove.volumes", "remove volumes during removal").Bool()
+	containerStop              = flagOn(deletingCommands, "container.stop", "stop running docker container").Bool()
+	containerKillSignal        = flagOn(deletingCommands, "container.kill", "kill running docker container with the specified signal").Default("").String()
 
 	// image remove options
-	imageRemoveForceFlag         = kingpin.Flag("image.remove.force", "force removal of image").Bool()
-	imageRemovePruneChildrenFlag = kingpin.Flag("image.remove.prunechildren", "prune children on removal").Bool()
+	imageRemoveForceFlag         = flagOn(deletingCommands, "image.remove.force", "force removal of image").Bool()
+	imageRemovePruneChildrenFlag = flagOn(deletingCommands, "image.remove.prunechildren", "prune children on removal").Bool()
+
+	// notifications
+	notifyCommands    = []*kingpin.CmdClause{purgeCommand, daemonCommand}
+	notifyWebhookFlag = flagOn(notifyCommands, "notify.webhook", "post a json summary of the run to this url").Strings()
+	notifySlackFlag   = flagOn(notifyCommands, "notify.slack", "post a summary of the run to this slack compatible webhook url").Strings()
+	notifyOnFlag      = flagOn(notifyCommands, "notify.on", "when to notify: always, failure or deleted").Default("always").Enum("always", "failure", "deleted")
+	notifyRetriesFlag = flagOn(notifyCommands, "notify.retries", "number of retries if a webhook fails").Default("3").Int()
+
+	// metrics
+	metricsTextfileFlag = purgeCommand.Flag("metrics.textfile", "write prometheus metrics to this file after the purge (for the node exporter textfile collector)").String()
+	metricsLabelFlag    = flagOn([]*kingpin.CmdClause{purgeCommand, daemonCommand, serveCommand}, "metrics.label", "break down the entity metrics by the value of this label").String()
+
+	// trash
+	trashFlag               = flagOn(trashCommands, "trash", "move containers and images to the trash instead of deleting them, requires --state-file").Bool()
+	emptyTrashOlderThanFlag = emptyTrashCommand.Flag("older-than", "only delete entities that were trashed at least this long ago").Default("24h").Duration()
+	restoreIDArg            = restoreCommand.Arg("id", "id or id prefix of the trashed entity, or id, id prefix or tag of the archived image").Required().Strings()
+
+	// archive
+	archiveDirFlag     = flagOn(archiveCommands, "archive-dir", "save images to compressed tarballs in this directory before they are deleted").String()
+	archiveMaxSizeFlag = flagOn(archiveCommands, "archive.max-size", "delete the oldest archives when all archives exceed this size (e.g. 50GB)").String()
+	archiveMaxAgeFlag  = flagOn(archiveCommands, "archive.max-age", "delete archives older than this (e.g. 720h)").Duration()
+
+	// container records
+	containerRecordDirFlag    = flagOn(recordCommands, "container.record-dir", "save the configuration of containers to this directory before they are deleted, enables resurrect").String()
+	containerRecordExportFlag = flagOn(recordCommands, "container.record.export", "also export the filesystem of the containers to the record dir").Bool()
+	containerRecordCommitFlag = flagOn(recordCommands, "container.record.commit", "also commit the containers to an image").Bool()
+	resurrectIDArg            = resurrectCommand.Arg("id", "id, id prefix or name of the deleted container").Required().String()
+
+	// audit
+	auditFileFlag   = flagOn(deletingCommands, "audit.file", "append a json line for every purged entity to this file, dry runs are recorded too").String()
+	auditSyslogFlag = flagOn(deletingCommands, "audit.syslog", "send the audit records to syslog").Bool()
+
+	// state
+	stateFileFlag = flagOn(stateCommands, "state-file", "file to keep track of image usage, enables .LastUsed on images").String()
+
+	// budget
+	budgetFlag           = budgetCommand.Arg("size", "size the image storage should stay below (e.g. 40GB)").Required().String()
+	budgetContainersFlag = budgetCommand.Flag("containers", "count stopped containers towards the budget and remove them too").Bool()
+	budgetKeepLabelFlag  = budgetCommand.Flag("keep-label", "never purge entities that have this label").Default("docker-purge.keep").String()
+
+	// quota
+	quotaFlag      = quotaCommand.Flag("limit", "quota per owner in the format OWNER:count=N,size=SIZE, use * as OWNER for the default quota").Required().Strings()
+	quotaLabelFlag = quotaCommand.Flag("label", "label that identifies the owner of an entity").Default("team").String()
 )
 
 var containerListOptions = types.ContainerListOptions{
@@ -67,35 +202,61 @@ var networkListOptions = types.NetworkListOptions{}
 var containerRemoveOptions types.ContainerRemoveOptions
 var imageRemoveOptions types.ImageRemoveOptions
 
-type container struct {
-	IsImage     bool
-	IsContainer bool
-	IsNetwork   bool
-	types.Container
-}
+// container, image and network are the documents the filters operate on
+type container = purge.Container
+type image = purge.Image
+type network = purge.Network
 
-type image struct {
-	IsImage     bool
-	IsContainer bool
-	IsNetwork   bool
-	types.ImageSummary
-}
+func init() {
+	for _, command := range []*kingpin.CmdClause{watchCommand, listCommand, planCommand, budgetCommand, quotaCommand} {
+		command.Arg("filter", "jq filter to apply").StringVar(filterArg)
+	}
 
-type network struct {
-	IsImage     bool
-	IsContainer bool
-	IsNetwork   bool
-	types.NetworkResource
-	Created int64 // override NetworkResource Created
+	// deprecated budget and quota flags, use the budget and quota commands instead
+	purgeCommand.Flag("budget", "purge least recently used images until the image storage is below the specified size").Hidden().StringVar(budgetFlag)
+	purgeCommand.Flag("budget.containers", "count stopped containers towards the budget and remove them too").Hidden().BoolVar(budgetContainersFlag)
+	purgeCommand.Flag("budget.keep-label", "never purge entities that have this label").Hidden().Default("docker-purge.keep").StringVar(budgetKeepLabelFlag)
+	purgeCommand.Flag("quota", "quota per owner in the format OWNER:count=N,size=SIZE").Hidden().StringsVar(quotaFlag)
+	purgeCommand.Flag("quota.label", "label that identifies the owner of an entity").Hidden().Default("team").StringVar(quotaLabelFlag)
 }
 
 func main() {
-	kingpin.Parse()
+	args := os.Args[1:]
+	if isPluginInvocation() {
+		kingpin.CommandLine.Name = "docker"
+		args = pluginArgs(args)
+	}
+	command := kingpin.MustParse(kingpin.CommandLine.Parse(args))
+	switch command {
+	case pluginMetadataCommand.FullCommand():
+		handlePluginMetadata()
+		os.Exit(0)
+	case versionCommand.FullCommand():
+		fmt.Fprintf(os.Stdout, "docker-purge %s (%s), built %s\n", Version, VersionHash, BuildDate)
+		os.Exit(0)
+	case testCommand.FullCommand():
+		handleTest()
+		os.Exit(0)
+	case schemaCommand.FullCommand():
+		handleSchema()
+		os.Exit(0)
+	}
+	if command == purgeCommand.FullCommand() {
+		if kingpin.CommandLine.GetCommand(*filterArg) != nil {
+			fmt.Fprintf(os.Stderr, "the flags of %s have to follow the command, e.g. `docker-purge %s --help`\n", *filterArg, *filterArg)
+			os.Exit(1)
+		}
+		if deprecatedListFlags() {
+			command = listCommand.FullCommand()
+		}
+		command = deprecatedModeFlags(command)
+	}
 
 	if !jq.IsValidFilter(*filterArg) {
 		fmt.Fprintf(os.Stderr, "Invalid filter `%s'\n", *filterArg)
 		os.Exit(1)
 	}
+	warnUnknownFields("", *filterArg)
 
 	if *forceRemoveFlag {
 		*containerRemoveForceFlag = true
@@ -121,255 +282,494 @@ func main() {
 		PruneChildren: *imageRemovePruneChildrenFlag,
 	}
 
-	dockerClient, err := client.NewEnvClient()
+	if *fromSnapshotFlag != "" {
+		src, err := loadSnapshot(*fromSnapshotFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		// older_than compares with the time the snapshot was taken, like AgeSeconds
+		jq.Now = func() time.Time { return src.Created }
+		if command == planCommand.FullCommand() {
+			handlePlan(src)
+		} else {
+			handleList(src)
+		}
+		os.Exit(0)
+	}
+
+	hosts, err := flagHosts()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
-	defer dockerClient.Close()
-
-	handleListFlags(dockerClient)
-	handlePurge(dockerClient)
-
-	os.Exit(0)
-}
+	// several hosts are told apart by the host of the purge
+	var dockerHost string
+	if len(hosts) <= 1 {
+		dockerHost = clientHost(hosts)
+	}
 
-func handleListFlags(dockerClient *client.Client) {
-	if *listAllFlag {
-		*listContainerFlag = true
-		*listImageFlag = true
-		*listNetworkFlag = true
+	// origin holds the stores of the run, the handlers set the rule
+	origin := purgeOrigin{Invoker: currentUser(), metrics: newMetricsRegistry()}
+	if *auditFileFlag != "" || *auditSyslogFlag {
+		if origin.audit, err = openAuditLog(*auditFileFlag, *auditSyslogFlag, dockerHost); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to open audit log: %s\n", err.Error())
+			os.Exit(1)
+		}
+		defer origin.audit.close()
 	}
 
-	var allEntities []interface{}
+	if *archiveDirFlag != "" {
+		var maxSize uint64
+		if *archiveMaxSizeFlag != "" {
+			if maxSize, err = parseBytes(*archiveMaxSizeFlag); err != nil {
+				fmt.Fprintf(os.Stderr, "invalid archive size `%s': %s\n", *archiveMaxSizeFlag, err.Error())
+				os.Exit(1)
+			}
+		}
+		if origin.archive, err = openImageArchive(*archiveDirFlag, int64(maxSize), *archiveMaxAgeFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to open archive: %s\n", err.Error())
+			os.Exit(1)
+		}
+	}
 
-	if *listContainerFlag {
-		entities, err := selectContainers(dockerClient, *filterArg)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err.Error())
+	if *containerRecordDirFlag != "" {
+		if origin.recorder, err = openContainerRecorder(*containerRecordDirFlag, *containerRecordExportFlag, *containerRecordCommitFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to open record dir: %s\n", err.Error())
 			os.Exit(1)
 		}
-		allEntities = append(allEntities, entities)
+	} else if command == resurrectCommand.FullCommand() {
+		fmt.Fprintln(os.Stderr, "resurrect requires --container.record-dir")
+		os.Exit(1)
 	}
 
-	if *listImageFlag {
-		entities, err := selectImages(dockerClient, *filterArg)
-		if err != nil {
+	if len(hosts) > 1 {
+		if err := checkMultiHost(command); err != nil {
 			fmt.Fprintln(os.Stderr, err.Error())
 			os.Exit(1)
 		}
-		allEntities = append(allEntities, entities)
+		handleHostsPurge(hosts, origin)
+		os.Exit(0)
 	}
 
-	if *listNetworkFlag {
-		entities, err := selectNetworks(dockerClient, *filterArg)
-		if err != nil {
+	var dockerClient *client.Client
+	if len(hosts) == 1 {
+		dockerClient, err = hosts[0].newClient()
+	} else {
+		dockerClient, err = client.NewEnvClient()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	defer dockerClient.Close()
+	if err := negotiateAPIVersion(dockerClient, *apiVersionFlag); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", err.Error())
+	}
+	reportAPIFeatures(os.Stderr, "", dockerClient)
+
+	if *stateFileFlag != "" {
+		if origin.state, err = loadState(*stateFileFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to load state: %s\n", err.Error())
+			os.Exit(1)
+		}
+		if err := refreshState(dockerClient, origin.state); err != nil {
 			fmt.Fprintln(os.Stderr, err.Error())
 			os.Exit(1)
 		}
-		allEntities = append(allEntities, entities)
 	}
 
-	if *listContainerFlag || *listImageFlag || *listNetworkFlag {
-		if len(allEntities) == 0 {
-			fmt.Println("[]")
-		} else {
-			enc := json.NewEncoder(os.Stdout)
-			enc.SetIndent("", "  ")
-			enc.Encode(allEntities)
-		}
+	if origin.state == nil && (*trashFlag || command == emptyTrashCommand.FullCommand()) {
+		fmt.Fprintln(os.Stderr, "the trash requires --state-file")
+		os.Exit(1)
+	}
+	if origin.state == nil && origin.archive == nil && command == restoreCommand.FullCommand() {
+		fmt.Fprintln(os.Stderr, "restore requires --state-file or --archive-dir")
+		os.Exit(1)
+	}
+
+	switch command {
+	case listCommand.FullCommand():
+		handleList(dockerSource{dockerClient: dockerClient, state: origin.state})
+		os.Exit(0)
+	case planCommand.FullCommand():
+		handlePlan(dockerSource{dockerClient: dockerClient, state: origin.state})
+		os.Exit(0)
+	case snapshotSaveCommand.FullCommand():
+		handleSnapshotSave(dockerClient, dockerHost, origin.state)
+		os.Exit(0)
+	case applyCommand.FullCommand():
+		handleApply(dockerClient, origin)
+		os.Exit(0)
+	case emptyTrashCommand.FullCommand():
+		handleEmptyTrash(dockerClient, origin)
+		os.Exit(0)
+	case restoreCommand.FullCommand():
+		handleRestore(dockerClient, origin.state, origin.archive)
+		os.Exit(0)
+	case resurrectCommand.FullCommand():
+		handleResurrect(dockerClient, origin.recorder)
+		os.Exit(0)
+	case watchCommand.FullCommand():
+		handleWatch(dockerClient, origin)
+		os.Exit(0)
+	case daemonCommand.FullCommand():
+		handleDaemon(dockerClient, dockerHost, origin)
+		os.Exit(0)
+	case serveCommand.FullCommand():
+		handleServe(dockerClient, origin)
+		os.Exit(0)
+	case budgetCommand.FullCommand():
+		handleBudget(dockerClient, origin)
+		os.Exit(0)
+	case quotaCommand.FullCommand():
+		handleQuota(dockerClient, origin)
 		os.Exit(0)
 	}
+
+	handlePurge(dockerClient, dockerHost, origin)
+	os.Exit(0)
 }
 
-func handlePurge(dockerClient *client.Client) {
-	if *dryRunFlag {
-		fmt.Fprintln(os.Stdout, "Dry mode on")
+// deprecatedListFlags maps the deprecated --list-* flags onto the list command,
+// it reports whether any of them was set
+func deprecatedListFlags() bool {
+	flags := map[string]*bool{
+		"list-all":        listAllFlag,
+		"list-containers": listContainerFlag,
+		"list-images":     listImageFlag,
+		"list-networks":   listNetworkFlag,
 	}
+	used := false
+	for _, name := range []string{"list-all", "list-containers", "list-images", "list-networks"} {
+		if *flags[name] {
+			fmt.Fprintf(os.Stderr, "warning: --%s is deprecated, use `docker-purge list` instead\n", name)
+			used = true
+		}
+	}
+	if !used {
+		return false
+	}
+	if !*listAllFlag {
+		*limitToContainerFlag = *listContainerFlag
+		*limitToImageFlag = *listImageFlag
+		*limitToNetworkFlag = *listNetworkFlag
+	}
+	return true
+}
 
-	if !*limitToContainerFlag && !*limitToImageFlag && !*limitToNetworkFlag {
-		*limitToContainerFlag = true
-		*limitToImageFlag = true
-		*limitToNetworkFlag = true
+// deprecatedModeFlags maps the deprecated --budget and --quota flags onto their commands,
+// it returns the command to run
+func deprecatedModeFlags(command string) string {
+	if *budgetFlag != "" && len(*quotaFlag) > 0 {
+		fmt.Fprintln(os.Stderr, "--budget and --quota cannot be combined")
+		os.Exit(1)
+	}
+	if *budgetFlag != "" {
+		fmt.Fprintln(os.Stderr, "warning: --budget is deprecated, use `docker-purge budget` instead")
+		return budgetCommand.FullCommand()
 	}
+	if len(*quotaFlag) > 0 {
+		fmt.Fprintln(os.Stderr, "warning: --quota is deprecated, use `docker-purge quota` instead")
+		return quotaCommand.FullCommand()
+	}
+	return command
+}
+
+// handleList prints the entities that match the filter,
+// the kinds are limited by --containers, --images and --networks
+func handleList(src entitySource) {
+	p := flagPolicy()
+	var allEntities []interface{}
+	var rows [][]string
 
-	if *limitToContainerFlag {
-		containersToDelete, err := selectContainers(dockerClient, *filterArg)
+	if p.Containers {
+		entities, err := src.containers(p.Filter)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err.Error())
 			os.Exit(1)
 		}
-
-		if !*dryRunFlag {
-			deleteContainers(dockerClient, containersToDelete, containerRemoveOptions, *containerKillSignal, *containerStop)
-		} else {
-			for _, container := range containersToDelete {
-				fmt.Fprintf(os.Stdout, "Would delete container %s\n", container.ID)
-			}
+		allEntities = append(allEntities, entities)
+		for _, e := range entities {
+			rows = append(rows, []string{"container", e.ID, strings.Join(e.Names, ","), time.Unix(e.Created, 0).Format(time.RFC3339)})
 		}
 	}
 
-	if *limitToImageFlag {
-		imagesToDelete, err := selectImages(dockerClient, *filterArg)
+	if p.Images {
+		entities, err := src.images(p.Filter)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err.Error())
 			os.Exit(1)
 		}
-
-		if !*dryRunFlag {
-			deleteImages(dockerClient, imagesToDelete, imageRemoveOptions)
-		} else {
-			for _, image := range imagesToDelete {
-				fmt.Fprintf(os.Stdout, "Would delete image %s\n", image.ID)
-			}
+		allEntities = append(allEntities, entities)
+		for _, e := range entities {
+			rows = append(rows, []string{"image", e.ID, strings.Join(e.RepoTags, ","), time.Unix(e.Created, 0).Format(time.RFC3339)})
 		}
 	}
 
-	if *limitToNetworkFlag {
-		networksToDelete, err := selectNetworks(dockerClient, *filterArg)
+	if p.Networks {
+		entities, err := src.networks(p.Filter)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err.Error())
 			os.Exit(1)
 		}
+		allEntities = append(allEntities, entities)
+		for _, e := range entities {
+			rows = append(rows, []string{"network", e.ID, e.Name, time.Unix(e.Created, 0).Format(time.RFC3339)})
+		}
+	}
 
-		if !*dryRunFlag {
-			deleteNetworks(dockerClient, networksToDelete)
-		} else {
-			for _, image := range networksToDelete {
-				fmt.Fprintf(os.Stdout, "Would delete image %s\n", image.ID)
-			}
+	if *listFormatFlag == "table" {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KIND\tID\tNAME\tCREATED")
+		for _, row := range rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
 		}
+		w.Flush()
+		return
+	}
+	if len(allEntities) == 0 {
+		fmt.Println("[]")
+		return
 	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(allEntities)
 }
 
-func selectContainers(dockerClient *client.Client, filter string) ([]container, error) {
-	entities, err := dockerClient.ContainerList(context.Background(), containerListOptions)
-	if err != nil {
-		return nil, err
-	}
-	var selectedContainers []container
-	for _, e := range entities {
-		c := container{IsContainer: true, Container: e}
-		if filter != "" {
-			buf, err := json.Marshal(c)
-			if err != nil {
-				return nil, err
-			}
+func handlePurge(dockerClient *client.Client, dockerHost string, origin purgeOrigin) {
+	p := flagPolicy()
+	if p.Dry {
+		fmt.Fprintln(os.Stdout, "Dry mode on")
+	}
 
-			ok, err := jq.MatchesFilter(string(buf), filter)
-			if err != nil {
-				return nil, err
-			}
+	nt := flagNotifier()
+	var used int64
+	if nt != nil {
+		used = nt.usedSpace(dockerClient, p)
+	}
 
-			if ok {
-				selectedContainers = append(selectedContainers, c)
-			}
-		} else {
-			selectedContainers = append(selectedContainers, c)
+	start := time.Now()
+	origin.Rule = "cli"
+	summary, err := runPurge(dockerClient, p, origin)
+	origin.metrics.observeRun("cli", p, summary, err, time.Since(start))
+	if nt != nil {
+		nt.notify(newNotification(dockerHost, "cli", p, start, summary, err, used, nt.usedSpace(dockerClient, p)))
+	}
+	if *metricsTextfileFlag != "" {
+		if err := origin.metrics.writeTextfile(*metricsTextfileFlag, dockerClient, *metricsLabelFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to write metrics: %s\n", err.Error())
 		}
 	}
-	return selectedContainers, nil
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
 }
 
-func deleteContainers(dockerClient *client.Client, containers []container, removeOptions types.ContainerRemoveOptions, killContainerSignal string, stopContainers bool) {
-	for _, container := range containers {
-		if killContainerSignal != "" || stopContainers {
-			details, err := dockerClient.ContainerInspect(context.Background(), container.ID)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "unable to inspect container %s: %s\n", container.ID, err.Error())
-				continue
-			}
-			if details.State.Running {
-				if killContainerSignal != "" {
-					if err := dockerClient.ContainerKill(context.Background(), container.ID, killContainerSignal); err != nil {
-						fmt.Fprintf(os.Stderr, "unable to kill container %s: %s\n", container.ID, err.Error())
-						continue
-					}
-				}
-				if stopContainers {
-					if err := dockerClient.ContainerStop(context.Background(), container.ID, nil); err != nil {
-						fmt.Fprintf(os.Stderr, "unable to stop container %s: %s\n", container.ID, err.Error())
-						continue
-					}
+// policy describes which entities should be purged
+type policy struct {
+	Filter     string `json:"filter"`
+	Containers bool   `json:"containers"`
+	Images     bool   `json:"images"`
+	Networks   bool   `json:"networks"`
+	Dry        bool   `json:"dry"`
+	// Trash moves containers and images to the trash instead of deleting them, networks are always deleted
+	Trash bool `json:"trash"`
+}
+
+// purgeSummary and purgeResult are the results of a purge
+type purgeSummary = purge.Summary
+type purgeResult = purge.Result
+
+// flagPolicy returns the policy specified by the command line flags
+func flagPolicy() policy {
+	if !*limitToContainerFlag && !*limitToImageFlag && !*limitToNetworkFlag {
+		*limitToContainerFlag = true
+		*limitToImageFlag = true
+		*limitToNetworkFlag = true
+	}
+	return policy{
+		Filter:     *filterArg,
+		Containers: *limitToContainerFlag,
+		Images:     *limitToImageFlag,
+		Networks:   *limitToNetworkFlag,
+		Dry:        *dryRunFlag,
+		Trash:      *trashFlag,
+	}
+}
+
+// purgeOrigin describes why entities are purged, it is recorded in the audit log
+type purgeOrigin struct {
+	// Rule is the policy or mode that triggered the purge
+	Rule string
+	// Invoker is the user or client that triggered the purge
+	Invoker string
+	Filter  string
+	Dry     bool
+	// Trash moves containers and images to the trash instead of deleting them
+	Trash bool
+	// Host is the name of the docker host, empty if only one host is purged
+	Host string
+	// trashed are the trash entries of the entities that are deleted for good,
+	// they are archived and recorded under their original names and tags
+	trashed map[string]*trashEntry
+
+	dockerClient *client.Client
+	// started is the start of the run, archives created since then are never pruned
+	started time.Time
+
+	// the stores of the process, nil if they are not enabled by their flags
+	state    *stateStore
+	audit    *auditLog
+	archive  *imageArchive
+	recorder *containerRecorder
+	metrics  *metricsRegistry
+}
+
+// prefix returns the prefix of messages about the purge, it names the host if several hosts are purged
+func (o purgeOrigin) prefix() string {
+	if o.Host == "" {
+		return ""
+	}
+	return "[" + o.Host + "] "
+}
+
+func (o purgeOrigin) verb() string {
+	if o.Trash {
+		return "trash"
+	}
+	return "delete"
+}
+
+// options returns the options of the purge library for a purge with this origin,
+// the results are printed, audited and the trash, archive and recorder are plugged in
+func (o purgeOrigin) options(removeOptions types.ContainerRemoveOptions, killContainerSignal string, stopContainers bool) purge.Options {
+	opts := purge.Options{
+		Filter:                 o.Filter,
+		Dry:                    o.Dry,
+		ContainerRemoveOptions: removeOptions,
+		ImageRemoveOptions:     imageRemoveOptions,
+		KillSignal:             killContainerSignal,
+		Stop:                   stopContainers,
+		ImageLastUsed:          o.state.imageLastUsed,
+		BeforeRemoveContainer: func(c container) error {
+			// record before stopping, so the container is resurrected in the state it was in
+			return o.recorder.save(o.dockerClient, c.ID, o.trashed[c.ID])
+		},
+		BeforeRemoveImage: func(i image) error {
+			// never delete an image that could not be archived
+			return o.archive.save(o.dockerClient, i, o.trashed[i.ID])
+		},
+		Report: func(result purgeResult, labels map[string]string) {
+			if result.Error != "" {
+				fmt.Fprintln(os.Stderr, o.prefix()+result.Error)
+			} else if o.Dry {
+				verb := o.verb()
+				if result.Kind == "network" {
+					verb = "delete"
 				}
+				fmt.Fprintf(os.Stdout, "%sWould %s %s %s\n", o.prefix(), verb, result.Kind, result.ID)
 			}
+			o.audit.record(o, result, labels)
+		},
+	}
+	if o.Trash {
+		opts.Skip = func(kind, id string) bool {
+			return o.state.isTrashed(id)
+		}
+		opts.RemoveContainer = func(c container) error {
+			return trashContainer(o.dockerClient, o.state, c, killContainerSignal, stopContainers)
 		}
-		if err := dockerClient.ContainerRemove(context.Background(), container.ID, removeOptions); err != nil {
-			fmt.Fprintf(os.Stderr, "unable to delete container %s: %s\n", container.ID, err.Error())
+		opts.RemoveImage = func(i image) error {
+			return trashImage(o.dockerClient, o.state, i)
 		}
 	}
+	return opts
 }
 
-func selectImages(dockerClient *client.Client, filter string) ([]image, error) {
-	entities, err := dockerClient.ImageList(context.Background(), imageListOptions)
-	if err != nil {
-		return nil, err
-	}
-	var selectedImages []image
-	for _, e := range entities {
-		i := image{IsImage: true, ImageSummary: e}
-		if filter != "" {
-			buf, err := json.Marshal(i)
-			if err != nil {
-				return nil, err
-			}
-
-			ok, err := jq.MatchesFilter(string(buf), filter)
-			if err != nil {
-				return nil, err
-			}
+// runPurge deletes all entities that match the policy
+func runPurge(dockerClient *client.Client, p policy, origin purgeOrigin) (*purgeSummary, error) {
+	origin.Filter = p.Filter
+	origin.Dry = p.Dry
+	origin.Trash = p.Trash
+	origin.dockerClient = dockerClient
+	if origin.started.IsZero() {
+		origin.started = time.Now()
+	}
 
-			if ok {
-				selectedImages = append(selectedImages, i)
-			}
-		} else {
-			selectedImages = append(selectedImages, i)
-		}
+	opts := origin.options(containerRemoveOptions, *containerKillSignal, *containerStop)
+	opts.Containers = p.Containers
+	opts.Images = p.Images
+	opts.Networks = p.Networks
+	summary, err := purge.Run(context.Background(), dockerClient, opts)
+	if err == nil && summary.Images > 0 {
+		pruneArchive(origin)
 	}
-	return selectedImages, nil
+	return summary, err
 }
 
-func deleteImages(dockerClient *client.Client, images []image, removeOptions types.ImageRemoveOptions) {
-	for _, image := range images {
-		if _, err := dockerClient.ImageRemove(context.Background(), image.ID, removeOptions); err != nil {
-			fmt.Fprintf(os.Stderr, "unable to delete image %s: %s\n", image.ID, err.Error())
-		}
+// pruneArchive removes archives above the size and age limits after images were deleted,
+// the archives of the images deleted by this run are kept
+func pruneArchive(origin purgeOrigin) {
+	if origin.Dry || origin.Trash {
+		return
 	}
+	if err := origin.archive.prune(origin.started); err != nil {
+		fmt.Fprintf(os.Stderr, "%sunable to prune archive: %s\n", origin.prefix(), err.Error())
+	}
+}
+
+func selectContainers(dockerClient *client.Client, filter string) ([]container, error) {
+	return purge.SelectContainers(context.Background(), dockerClient, filter)
+}
+
+func selectImages(dockerClient *client.Client, state *stateStore, filter string) ([]image, error) {
+	return purge.SelectImages(context.Background(), dockerClient, filter, state.imageLastUsed)
 }
 
 func selectNetworks(dockerClient *client.Client, filter string) ([]network, error) {
-	entities, err := dockerClient.NetworkList(context.Background(), networkListOptions)
-	if err != nil {
-		return nil, err
-	}
-	var selectedNetworks []network
-	for _, e := range entities {
-		n := network{IsNetwork: true, NetworkResource: e, Created: e.Created.Unix()}
-		if filter != "" {
-			buf, err := json.Marshal(n)
-			if err != nil {
-				return nil, err
-			}
+	return purge.SelectNetworks(context.Background(), dockerClient, filter)
+}
 
-			ok, err := jq.MatchesFilter(string(buf), filter)
-			if err != nil {
-				return nil, err
-			}
+func deleteContainers(dockerClient *client.Client, containers []container, removeOptions types.ContainerRemoveOptions, killContainerSignal string, stopContainers bool, origin purgeOrigin) []purgeResult {
+	origin.dockerClient = dockerClient
+	return purge.DeleteContainers(context.Background(), dockerClient, containers, origin.options(removeOptions, killContainerSignal, stopContainers))
+}
 
-			if ok {
-				selectedNetworks = append(selectedNetworks, n)
-			}
-		} else {
-			selectedNetworks = append(selectedNetworks, n)
-		}
+func deleteImages(dockerClient *client.Client, images []image, removeOptions types.ImageRemoveOptions, origin purgeOrigin) []purgeResult {
+	origin.dockerClient = dockerClient
+	if origin.started.IsZero() {
+		origin.started = time.Now()
+	}
+	opts := origin.options(containerRemoveOptions, *containerKillSignal, *containerStop)
+	opts.ImageRemoveOptions = removeOptions
+	results := purge.DeleteImages(context.Background(), dockerClient, images, opts)
+	if len(images) > 0 {
+		pruneArchive(origin)
 	}
-	return selectedNetworks, nil
+	return results
 }
 
-func deleteNetworks(dockerClient *client.Client, networks []network) {
-	for _, network := range networks {
-		if err := dockerClient.NetworkRemove(context.Background(), network.ID); err != nil {
-			fmt.Fprintf(os.Stderr, "unable to delete network %s: %s\n", network.ID, err.Error())
-		}
-	}
+func deleteNetworks(dockerClient *client.Client, networks []network, origin purgeOrigin) []purgeResult {
+	origin.dockerClient = dockerClient
+	return purge.DeleteNetworks(context.Background(), dockerClient, networks, origin.options(containerRemoveOptions, *containerKillSignal, *containerStop))
+}
+
+// purgeContainer deletes a single container, or just reports it in dry mode
+func purgeContainer(dockerClient *client.Client, c *container, origin purgeOrigin) bool {
+	results := deleteContainers(dockerClient, []container{*c}, containerRemoveOptions, *containerKillSignal, *containerStop, origin)
+	return len(results) == 1 && results[0].Error == ""
+}
+
+// purgeImage deletes a single image, or just reports it in dry mode
+func purgeImage(dockerClient *client.Client, i *image, origin purgeOrigin) bool {
+	results := deleteImages(dockerClient, []image{*i}, imageRemoveOptions, origin)
+	return len(results) == 1 && results[0].Error == ""
+}
+
+// purgeNetwork deletes a single network, or just reports it in dry mode
+func purgeNetwork(dockerClient *client.Client, n *network, origin purgeOrigin) bool {
+	results := deleteNetworks(dockerClient, []network{*n}, origin)
+	return len(results) == 1 && results[0].Error == ""
 }
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// metricsDiskUsageTTL is how long the metrics handler reuses the disk usage,
+// computing it is expensive for the daemon and several scrapers would multiply that
+var metricsDiskUsageTTL = 15 * time.Second
+
+// metricsRegistry keeps track of the purge runs and exposes them together with
+// the current docker entities in the prometheus text format
+type metricsRegistry struct {
+	mu sync.Mutex
+	// purged and failed map kind => rule => count
+	purged      map[string]map[string]float64
+	failed      map[string]map[string]float64
+	duration    map[string]float64
+	lastSuccess map[string]float64
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		purged:      make(map[string]map[string]float64),
+		failed:      make(map[string]map[string]float64),
+		duration:    make(map[string]float64),
+		lastSuccess: make(map[string]float64),
+	}
+}
+
+// observeRun records a purge run of a rule, dry runs are ignored
+func (m *metricsRegistry) observeRun(rule string, p policy, summary *purgeSummary, err error, duration time.Duration) {
+	if m == nil || p.Dry {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.duration[rule] = duration.Seconds()
+	if err != nil {
+		return
+	}
+	m.lastSuccess[rule] = float64(time.Now().Unix())
+	for _, kind := range []string{"container", "image", "network"} {
+		if m.purged[kind] == nil {
+			m.purged[kind] = make(map[string]float64)
+			m.failed[kind] = make(map[string]float64)
+		}
+		// make sure the series exist even if nothing was purged
+		m.purged[kind][rule] += 0
+		m.failed[kind][rule] += 0
+	}
+	for _, result := range summary.Results {
+		if result.Error != "" {
+			m.failed[result.Kind][rule]++
+		} else {
+			m.purged[result.Kind][rule]++
+		}
+	}
+}
+
+var metricLabelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricsWriter writes metrics in the prometheus text format
+type metricsWriter struct {
+	w       io.Writer
+	written map[string]bool
+	err     error
+}
+
+func (mw *metricsWriter) write(name, help, typ string, labels map[string]string, value float64) {
+	if mw.err != nil {
+		return
+	}
+	if !mw.written[name] {
+		mw.written[name] = true
+		if _, mw.err = fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ); mw.err != nil {
+			return
+		}
+	}
+	if pairs := formatMetricLabels(labels); pairs != "" {
+		_, mw.err = fmt.Fprintf(mw.w, "%s{%s} %v\n", name, pairs, value)
+	} else {
+		_, mw.err = fmt.Fprintf(mw.w, "%s %v\n", name, value)
+	}
+}
+
+// formatMetricLabels returns the labels sorted by name in the text format, without braces
+func formatMetricLabels(labels map[string]string) string {
+	var keys []string
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", k, metricLabelValueReplacer.Replace(labels[k])))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// metricLabels returns the labels of a series, the value of the owner label of the entity is added if specified
+func metricLabels(ownerLabel string, entityLabels map[string]string, labels map[string]string) map[string]string {
+	if ownerLabel != "" {
+		labels[metricLabelName(ownerLabel)] = entityLabels[ownerLabel]
+	}
+	return labels
+}
+
+// metricLabelName converts a docker label to a valid prometheus label name
+func metricLabelName(label string) string {
+	name := []byte(label)
+	for i, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= '0' && c <= '9' && i > 0) {
+			name[i] = '_'
+		}
+	}
+	return "label_" + string(name)
+}
+
+// metricSeries accumulates gauge values with the same labels,
+// the series are written in the order they were first added
+type metricSeries struct {
+	// index maps the formatted labels to the position of the series
+	index  map[string]int
+	labels []map[string]string
+	values []float64
+}
+
+func (s *metricSeries) add(labels map[string]string, value float64) {
+	key := formatMetricLabels(labels)
+	if i, ok := s.index[key]; ok {
+		s.values[i] += value
+		return
+	}
+	if s.index == nil {
+		s.index = make(map[string]int)
+	}
+	s.index[key] = len(s.labels)
+	s.labels = append(s.labels, labels)
+	s.values = append(s.values, value)
+}
+
+func (s *metricSeries) write(mw *metricsWriter, name, help string) {
+	for i := range s.labels {
+		mw.write(name, help, "gauge", s.labels[i], s.values[i])
+	}
+}
+
+// write writes the run metrics and the current docker entities, the containers and images are taken from the disk usage
+func (m *metricsRegistry) write(w io.Writer, dockerClient *client.Client, du types.DiskUsage, ownerLabel string) error {
+	networks, err := dockerClient.NetworkList(context.Background(), networkListOptions)
+	if err != nil {
+		return err
+	}
+
+	mw := &metricsWriter{w: w, written: make(map[string]bool)}
+
+	var containers, containerBytes metricSeries
+	for _, c := range du.Containers {
+		labels := metricLabels(ownerLabel, c.Labels, map[string]string{"state": c.State})
+		containers.add(labels, 1)
+		containerBytes.add(labels, float64(c.SizeRw))
+	}
+	containers.write(mw, "docker_purge_containers", "Number of docker containers.")
+	containerBytes.write(mw, "docker_purge_containers_bytes", "Size of the writable layers of the docker containers.")
+
+	var images, imageBytes metricSeries
+	for _, i := range du.Images {
+		labels := metricLabels(ownerLabel, i.Labels, map[string]string{"dangling": fmt.Sprint(isDangling(i.RepoTags))})
+		images.add(labels, 1)
+		imageBytes.add(labels, float64(i.Size))
+	}
+	images.write(mw, "docker_purge_images", "Number of docker images.")
+	imageBytes.write(mw, "docker_purge_images_bytes", "Size of the docker images.")
+
+	var networkCount metricSeries
+	for _, n := range networks {
+		networkCount.add(metricLabels(ownerLabel, n.Labels, map[string]string{"driver": n.Driver}), 1)
+	}
+	networkCount.write(mw, "docker_purge_networks", "Number of docker networks.")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, kind := range sortedKeys(m.purged) {
+		for _, rule := range sortedKeys(m.purged[kind]) {
+			mw.write("docker_purge_purged_total", "Number of purged entities.", "counter", map[string]string{"kind": kind, "rule": rule}, m.purged[kind][rule])
+		}
+	}
+	for _, kind := range sortedKeys(m.failed) {
+		for _, rule := range sortedKeys(m.failed[kind]) {
+			mw.write("docker_purge_failed_total", "Number of entities that could not be purged.", "counter", map[string]string{"kind": kind, "rule": rule}, m.failed[kind][rule])
+		}
+	}
+	for _, rule := range sortedKeys(m.duration) {
+		mw.write("docker_purge_run_duration_seconds", "Duration of the last run.", "gauge", map[string]string{"rule": rule}, m.duration[rule])
+	}
+	for _, rule := range sortedKeys(m.lastSuccess) {
+		mw.write("docker_purge_last_success_timestamp_seconds", "Unix time of the last successful run.", "gauge", map[string]string{"rule": rule}, m.lastSuccess[rule])
+	}
+	return mw.err
+}
+
+// writeTextfile writes the metrics for the node exporter textfile collector,
+// the file is replaced atomically so the collector never reads a partial file
+func (m *metricsRegistry) writeTextfile(path string, dockerClient *client.Client, ownerLabel string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	du, err := dockerDiskUsage(dockerClient)
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := m.write(tmp, dockerClient, du, ownerLabel); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// handler serves the metrics, the disk usage is reused for metricsDiskUsageTTL
+func (m *metricsRegistry) handler(dockerClient *client.Client, ownerLabel string) http.HandlerFunc {
+	var mu sync.Mutex
+	var du types.DiskUsage
+	var fetched time.Time
+	return func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if time.Since(fetched) >= metricsDiskUsageTTL {
+			current, err := dockerDiskUsage(dockerClient)
+			if err != nil {
+				mu.Unlock()
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			du, fetched = current, time.Now()
+		}
+		cached := du
+		mu.Unlock()
+
+		var buf strings.Builder
+		if err := m.write(&buf, dockerClient, cached, ownerLabel); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		io.WriteString(w, buf.String())
+	}
+}
+
+func isDangling(repoTags []string) bool {
+	return len(repoTags) == 0 || (len(repoTags) == 1 && repoTags[0] == "<none>:<none>")
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch v := m.(type) {
+	case map[string]float64:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]map[string]float64:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
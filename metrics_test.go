@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricLabelName(t *testing.T) {
+	tests := map[string]string{
+		"team":             "label_team",
+		"com.example/team": "label_com_example_team",
+		"team-2":           "label_team_2",
+		"2team":            "label__team",
+		"Team_A":           "label_Team_A",
+	}
+	for label, name := range tests {
+		require.Equal(t, name, metricLabelName(label), label)
+	}
+}
+
+func TestObserveRun(t *testing.T) {
+	m := newMetricsRegistry()
+	summary := &purgeSummary{Results: []purgeResult{
+		{Kind: "container", ID: "c1"},
+		{Kind: "container", ID: "c2"},
+		{Kind: "image", ID: "sha256:i1", Error: "image is being used"},
+	}}
+
+	// dry runs are ignored
+	m.observeRun("cli", policy{Dry: true}, summary, nil, time.Second)
+	require.Empty(t, m.duration)
+
+	before := float64(time.Now().Unix())
+	m.observeRun("cli", policy{}, summary, nil, 2*time.Second)
+	require.Equal(t, 2.0, m.duration["cli"])
+	require.True(t, m.lastSuccess["cli"] >= before)
+	require.Equal(t, map[string]float64{"cli": 2}, m.purged["container"])
+	require.Equal(t, map[string]float64{"cli": 0}, m.purged["image"])
+	require.Equal(t, map[string]float64{"cli": 1}, m.failed["image"])
+	require.Equal(t, map[string]float64{"cli": 0}, m.purged["network"])
+
+	// failed runs only record their duration
+	m.observeRun("watch", policy{}, nil, errors.New("cannot connect"), time.Second)
+	require.Equal(t, 1.0, m.duration["watch"])
+	require.NotContains(t, m.lastSuccess, "watch")
+	require.NotContains(t, m.purged["container"], "watch")
+}
+
+func TestMetricsWrite(t *testing.T) {
+	docker := newFakeDocker(t)
+	defer docker.close()
+	team := func(name string) map[string]string { return map[string]string{"com.example/team": name} }
+	docker.containers = []types.Container{
+		{ID: "c1", State: "running", SizeRw: 10, Labels: team("web")},
+		{ID: "c2", State: "exited", SizeRw: 5, Labels: team("web")},
+		{ID: "c3", State: "exited", SizeRw: 1, Labels: team("web")},
+		{ID: "c4", State: "exited", SizeRw: 2},
+	}
+	docker.images = []types.ImageSummary{
+		{ID: "sha256:i1", RepoTags: []string{"firefox:latest"}, Size: 100, Labels: team(`say "hi"`)},
+		{ID: "sha256:i2", RepoTags: []string{"<none>:<none>"}, Size: 50},
+	}
+	docker.networks = []types.NetworkResource{{ID: "n1", Driver: "bridge"}, {ID: "n2", Driver: "bridge"}}
+
+	m := newMetricsRegistry()
+	m.observeRun("cli", policy{}, &purgeSummary{Results: []purgeResult{{Kind: "container", ID: "c5"}}}, nil, 2*time.Second)
+	m.lastSuccess["cli"] = 1500000000
+
+	dockerClient := docker.client(t)
+	du, err := dockerDiskUsage(dockerClient)
+	require.Nil(t, err, "Expected no Error")
+	var buf strings.Builder
+	require.Nil(t, m.write(&buf, dockerClient, du, "com.example/team"))
+	require.Equal(t, `# HELP docker_purge_containers Number of docker containers.
+# TYPE docker_purge_containers gauge
+docker_purge_containers{label_com_example_team="web",state="running"} 1
+docker_purge_containers{label_com_example_team="web",state="exited"} 2
+docker_purge_containers{label_com_example_team="",state="exited"} 1
+# HELP docker_purge_containers_bytes Size of the writable layers of the docker containers.
+# TYPE docker_purge_containers_bytes gauge
+docker_purge_containers_bytes{label_com_example_team="web",state="running"} 10
+docker_purge_containers_bytes{label_com_example_team="web",state="exited"} 6
+docker_purge_containers_bytes{label_com_example_team="",state="exited"} 2
+# HELP docker_purge_images Number of docker images.
+# TYPE docker_purge_images gauge
+docker_purge_images{dangling="false",label_com_example_team="say \"hi\""} 1
+docker_purge_images{dangling="true",label_com_example_team=""} 1
+# HELP docker_purge_images_bytes Size of the docker images.
+# TYPE docker_purge_images_bytes gauge
+docker_purge_images_bytes{dangling="false",label_com_example_team="say \"hi\""} 100
+docker_purge_images_bytes{dangling="true",label_com_example_team=""} 50
+# HELP docker_purge_networks Number of docker networks.
+# TYPE docker_purge_networks gauge
+docker_purge_networks{driver="bridge",label_com_example_team=""} 2
+# HELP docker_purge_purged_total Number of purged entities.
+# TYPE docker_purge_purged_total counter
+docker_purge_purged_total{kind="container",rule="cli"} 1
+docker_purge_purged_total{kind="image",rule="cli"} 0
+docker_purge_purged_total{kind="network",rule="cli"} 0
+# HELP docker_purge_failed_total Number of entities that could not be purged.
+# TYPE docker_purge_failed_total counter
+docker_purge_failed_total{kind="container",rule="cli"} 0
+docker_purge_failed_total{kind="image",rule="cli"} 0
+docker_purge_failed_total{kind="network",rule="cli"} 0
+# HELP docker_purge_run_duration_seconds Duration of the last run.
+# TYPE docker_purge_run_duration_seconds gauge
+docker_purge_run_duration_seconds{rule="cli"} 2
+# HELP docker_purge_last_success_timestamp_seconds Unix time of the last successful run.
+# TYPE docker_purge_last_success_timestamp_seconds gauge
+docker_purge_last_success_timestamp_seconds{rule="cli"} 1.5e+09
+`, buf.String())
+}
+
+func TestMetricsHandlerCachesDiskUsage(t *testing.T) {
+	docker := newFakeDocker(t)
+	defer docker.close()
+	docker.containers = []types.Container{{ID: "c1", State: "exited"}}
+
+	handler := newMetricsRegistry().handler(docker.client(t), "")
+	scrape := func() string {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/metrics", nil))
+		require.Equal(t, 200, w.Code)
+		return w.Body.String()
+	}
+
+	require.Contains(t, scrape(), `docker_purge_containers{state="exited"} 1`)
+	docker.update(func() { docker.containers = append(docker.containers, types.Container{ID: "c2", State: "exited"}) })
+	// the disk usage of the previous scrape is reused
+	require.Contains(t, scrape(), `docker_purge_containers{state="exited"} 1`)
+
+	defer func(ttl time.Duration) { metricsDiskUsageTTL = ttl }(metricsDiskUsageTTL)
+	metricsDiskUsageTTL = 0
+	require.Contains(t, scrape(), `docker_purge_containers{state="exited"} 2`)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	units "github.com/docker/go-units"
+)
+
+const (
+	notifyAlways    = "always"
+	notifyOnFailure = "failure"
+	notifyOnDeleted = "deleted"
+)
+
+// notification is the payload that is posted to the webhooks after a run
+type notification struct {
+	Host           string        `json:"host"`
+	Rule           string        `json:"rule"`
+	Policy         policy        `json:"policy"`
+	Started        time.Time     `json:"started"`
+	Finished       time.Time     `json:"finished"`
+	Containers     int           `json:"containers"`
+	Images         int           `json:"images"`
+	Networks       int           `json:"networks"`
+	Failed         int           `json:"failed"`
+	ReclaimedBytes int64         `json:"reclaimedBytes"`
+	Error          string        `json:"error,omitempty"`
+	Results        []purgeResult `json:"results"`
+}
+
+// slackMessage is the payload of slack compatible webhooks
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+// notifier posts run summaries to the configured webhooks
+type notifier struct {
+	webhooks      []string
+	slackWebhooks []string
+	on            string
+	retries       int
+	httpClient    *http.Client
+}
+
+// flagNotifier returns the notifier configured by the command line flags, nil if no webhook is configured
+func flagNotifier() *notifier {
+	if len(*notifyWebhookFlag) == 0 && len(*notifySlackFlag) == 0 {
+		return nil
+	}
+	return &notifier{
+		webhooks:      *notifyWebhookFlag,
+		slackWebhooks: *notifySlackFlag,
+		on:            *notifyOnFlag,
+		retries:       *notifyRetriesFlag,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// usedSpace returns the space used by images and containers, it is used to calculate the reclaimed space.
+// Dry runs do not reclaim anything, so the space is not calculated for them.
+// -1 is returned if the space is unknown.
+func (nt *notifier) usedSpace(dockerClient *client.Client, p policy) int64 {
+	if p.Dry {
+		return -1
+	}
+	du, err := dockerDiskUsage(dockerClient)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to get disk usage: %s\n", err.Error())
+		return -1
+	}
+	used := du.LayersSize
+	for _, c := range du.Containers {
+		used += c.SizeRw
+	}
+	return used
+}
+
+// newNotification creates the notification of a run on the docker host,
+// usedBefore and usedAfter are the results of usedSpace before and after the run
+func newNotification(host, rule string, p policy, started time.Time, summary *purgeSummary, err error, usedBefore, usedAfter int64) *notification {
+	n := notification{
+		Host:     host,
+		Rule:     rule,
+		Policy:   p,
+		Started:  started,
+		Finished: time.Now(),
+		Results:  []purgeResult{},
+	}
+	if usedBefore >= 0 && usedAfter >= 0 && usedBefore > usedAfter {
+		n.ReclaimedBytes = usedBefore - usedAfter
+	}
+	if err != nil {
+		n.Error = err.Error()
+	}
+	if summary != nil {
+		n.Containers = summary.Containers
+		n.Images = summary.Images
+		n.Networks = summary.Networks
+		n.Failed = summary.Failed
+		if summary.Results != nil {
+			n.Results = summary.Results
+		}
+	}
+	return &n
+}
+
+// shouldNotify reports whether the notification should be sent
+func (nt *notifier) shouldNotify(n *notification) bool {
+	switch nt.on {
+	case notifyOnFailure:
+		return n.Error != "" || n.Failed > 0
+	case notifyOnDeleted:
+		return n.Containers+n.Images+n.Networks > 0
+	}
+	return true
+}
+
+// notify sends the notification to all webhooks, errors are reported but not returned
+func (nt *notifier) notify(n *notification) {
+	if !nt.shouldNotify(n) {
+		return
+	}
+	payload, err := json.Marshal(n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to encode notification: %s\n", err.Error())
+		return
+	}
+	for _, url := range nt.webhooks {
+		if err := nt.post(url, payload); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to notify %s: %s\n", url, err.Error())
+		}
+	}
+
+	if len(nt.slackWebhooks) == 0 {
+		return
+	}
+	if payload, err = json.Marshal(slackMessage{Text: n.text()}); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to encode notification: %s\n", err.Error())
+		return
+	}
+	for _, url := range nt.slackWebhooks {
+		if err := nt.post(url, payload); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to notify %s: %s\n", url, err.Error())
+		}
+	}
+}
+
+// post posts the payload, failed requests are retried with an increasing delay
+func (nt *notifier) post(url string, payload []byte) error {
+	var err error
+	delay := time.Second
+	for attempt := 0; attempt <= nt.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		var resp *http.Response
+		resp, err = nt.httpClient.Post(url, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		err = fmt.Errorf("unexpected status %s", resp.Status)
+		// client errors will not go away by retrying
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return err
+		}
+	}
+	return err
+}
+
+// text returns a human readable summary of the notification
+func (n *notification) text() string {
+	var b strings.Builder
+	verb := "purged"
+	if n.Policy.Dry {
+		verb = "would purge"
+	}
+	fmt.Fprintf(&b, "docker-purge on %s (%s) %s %d containers, %d images, %d networks", n.Host, n.Rule, verb, n.Containers, n.Images, n.Networks)
+	if !n.Policy.Dry {
+		fmt.Fprintf(&b, ", reclaimed %s", units.HumanSize(float64(n.ReclaimedBytes)))
+	}
+	if n.Failed > 0 {
+		fmt.Fprintf(&b, "\n%d entities could not be purged:", n.Failed)
+		for _, result := range n.Results {
+			if result.Error != "" {
+				fmt.Fprintf(&b, "\n• %s %s: %s", result.Kind, result.ID, result.Error)
+			}
+		}
+	}
+	if n.Error != "" {
+		fmt.Fprintf(&b, "\nrun failed: %s", n.Error)
+	}
+	return b.String()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifyRetries(t *testing.T) {
+	var requests int
+	var received notification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer srv.Close()
+
+	nt := &notifier{
+		webhooks:   []string{srv.URL},
+		on:         notifyAlways,
+		retries:    1,
+		httpClient: &http.Client{Timeout: time.Second},
+	}
+	summary := &purgeSummary{}
+	summary.Add([]purgeResult{
+		{Kind: "container", ID: "c1"},
+		{Kind: "image", ID: "i1", Error: "image is in use"},
+	})
+	nt.notify(newNotification("tcp://build-1:2376", "cli", policy{}, time.Now(), summary, nil, 100, 40))
+
+	require.Equal(t, 2, requests)
+	require.Equal(t, "tcp://build-1:2376", received.Host)
+	require.Equal(t, 1, received.Containers)
+	require.Equal(t, 1, received.Failed)
+	require.Equal(t, int64(60), received.ReclaimedBytes)
+	require.Len(t, received.Results, 2)
+}
+
+func TestNotifyOn(t *testing.T) {
+	nothing := newNotification("tcp://build-1:2376", "cli", policy{}, time.Now(), &purgeSummary{}, nil, -1, -1)
+	failed := newNotification("tcp://build-1:2376", "cli", policy{}, time.Now(), nil, errors.New("connection refused"), -1, -1)
+	deleted := newNotification("tcp://build-1:2376", "cli", policy{}, time.Now(), &purgeSummary{Images: 1}, nil, -1, -1)
+
+	tests := []struct {
+		On       string
+		Expected []bool
+	}{
+		{notifyAlways, []bool{true, true, true}},
+		{notifyOnFailure, []bool{false, true, false}},
+		{notifyOnDeleted, []bool{false, false, true}},
+	}
+	for _, test := range tests {
+		nt := &notifier{on: test.On}
+		require.Equal(t, test.Expected, []bool{nt.shouldNotify(nothing), nt.shouldNotify(failed), nt.shouldNotify(deleted)}, test.On)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// plan holds the entities a purge would delete, it is written by the plan command
+// and deleted by the apply command so the deletion can be reviewed first
+type plan struct {
+	Policy     policy        `json:"policy"`
+	Created    time.Time     `json:"created"`
+	Containers []plannedItem `json:"containers"`
+	Images     []plannedItem `json:"images"`
+	Networks   []plannedItem `json:"networks"`
+}
+
+// plannedItem is an entity of a plan
+type plannedItem struct {
+	ID    string   `json:"id"`
+	Names []string `json:"names,omitempty"`
+	// State is the state of a container when the plan was made
+	State string `json:"state,omitempty"`
+	InUse bool   `json:"inUse"`
+}
+
+// changed returns why the entity must not be deleted as planned, empty if it can be deleted
+func (item plannedItem) changed(state string, inUse bool) string {
+	switch {
+	case item.State != "" && item.State != state:
+		return fmt.Sprintf("it is %s now, it was %s when the plan was made", state, item.State)
+	case inUse && !item.InUse:
+		return "it is in use now"
+	case !inUse && item.InUse:
+		return "it is not in use anymore"
+	}
+	return ""
+}
+
+// skippedItem is an entity of a plan that was not deleted
+type skippedItem struct {
+	plannedItem
+	Reason string
+}
+
+// newPlan selects the entities that match the policy
+func newPlan(src entitySource, p policy) (*plan, error) {
+	pl := plan{
+		Policy:     p,
+		Created:    time.Now(),
+		Containers: []plannedItem{},
+		Images:     []plannedItem{},
+		Networks:   []plannedItem{},
+	}
+	if p.Containers {
+		containers, err := src.containers(p.Filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range containers {
+			pl.Containers = append(pl.Containers, plannedItem{ID: c.ID, Names: c.Names, State: c.State, InUse: c.InUse})
+		}
+	}
+	if p.Images {
+		images, err := src.images(p.Filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range images {
+			pl.Images = append(pl.Images, plannedItem{ID: i.ID, Names: i.RepoTags, InUse: i.InUse})
+		}
+	}
+	if p.Networks {
+		networks, err := src.networks(p.Filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range networks {
+			pl.Networks = append(pl.Networks, plannedItem{ID: n.ID, Names: []string{n.Name}, InUse: n.InUse})
+		}
+	}
+	return &pl, nil
+}
+
+func readPlan(path string) (*plan, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var pl plan
+	if err := json.Unmarshal(buf, &pl); err != nil {
+		return nil, fmt.Errorf("invalid plan %s: %s", path, err.Error())
+	}
+	return &pl, nil
+}
+
+func plannedItems(items []plannedItem) map[string]plannedItem {
+	byID := make(map[string]plannedItem, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+	return byID
+}
+
+// apply deletes the entities of the plan that still exist and did not change since the plan was made,
+// entities that are gone or were started, stopped or came into or out of use are reported in skipped
+func apply(dockerClient *client.Client, pl *plan, origin purgeOrigin) (summary *purgeSummary, skipped []skippedItem, err error) {
+	summary = &purgeSummary{}
+	origin.Filter = pl.Policy.Filter
+
+	// reasons maps the ids of the entities that still exist to why they are skipped, empty if they are not
+	skip := func(items []plannedItem, reasons map[string]string) {
+		for _, item := range items {
+			reason, ok := reasons[item.ID]
+			if !ok {
+				reason = "it does not exist anymore"
+			}
+			if reason != "" {
+				skipped = append(skipped, skippedItem{plannedItem: item, Reason: reason})
+			}
+		}
+	}
+
+	if len(pl.Containers) > 0 {
+		items := plannedItems(pl.Containers)
+		all, err := selectContainers(dockerClient, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		reasons := make(map[string]string)
+		var containers []container
+		for _, c := range all {
+			if item, ok := items[c.ID]; ok {
+				if reasons[c.ID] = item.changed(c.State, c.InUse); reasons[c.ID] == "" {
+					containers = append(containers, c)
+				}
+			}
+		}
+		skip(pl.Containers, reasons)
+		summary.Add(deleteContainers(dockerClient, containers, containerRemoveOptions, *containerKillSignal, *containerStop, origin))
+	}
+
+	if len(pl.Images) > 0 {
+		items := plannedItems(pl.Images)
+		all, err := selectImages(dockerClient, origin.state, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		reasons := make(map[string]string)
+		var images []image
+		for _, i := range all {
+			if item, ok := items[i.ID]; ok {
+				if reasons[i.ID] = item.changed("", i.InUse); reasons[i.ID] == "" {
+					images = append(images, i)
+				}
+			}
+		}
+		skip(pl.Images, reasons)
+		summary.Add(deleteImages(dockerClient, images, imageRemoveOptions, origin))
+	}
+
+	if len(pl.Networks) > 0 {
+		items := plannedItems(pl.Networks)
+		all, err := selectNetworks(dockerClient, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		reasons := make(map[string]string)
+		var networks []network
+		for _, n := range all {
+			if item, ok := items[n.ID]; ok {
+				if reasons[n.ID] = item.changed("", n.InUse); reasons[n.ID] == "" {
+					networks = append(networks, n)
+				}
+			}
+		}
+		skip(pl.Networks, reasons)
+		summary.Add(deleteNetworks(dockerClient, networks, origin))
+	}
+	return summary, skipped, nil
+}
+
+func handlePlan(src entitySource) {
+	pl, err := newPlan(src, flagPolicy())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	buf, err := json.MarshalIndent(pl, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	buf = append(buf, '\n')
+	if *planOutFlag == "" {
+		os.Stdout.Write(buf)
+		return
+	}
+	if err := ioutil.WriteFile(*planOutFlag, buf, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stdout, "planned %d containers, %d images, %d networks, written to %s\n",
+		len(pl.Containers), len(pl.Images), len(pl.Networks), *planOutFlag)
+}
+
+func handleApply(dockerClient *client.Client, origin purgeOrigin) {
+	pl, err := readPlan(*applyPlanArg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	origin.Rule = "apply"
+	origin.Dry = *dryRunFlag
+	origin.Trash = *trashFlag
+	summary, skipped, err := apply(dockerClient, pl, origin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	for _, item := range skipped {
+		fmt.Fprintf(os.Stderr, "skipping %s, %s\n", item.ID, item.Reason)
+	}
+	if summary.Failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanApply(t *testing.T) {
+	docker := newE2EDocker(t)
+	defer docker.close()
+	dockerClient := docker.client(t)
+
+	pl, err := newPlan(dockerSource{dockerClient: dockerClient}, policy{Filter: `.State == "exited"`, Containers: true})
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, []plannedItem{
+		{ID: "c2", Names: []string{"/firefox-old"}, State: "exited"},
+		{ID: "c3", Names: []string{"/chrome"}, State: "exited"},
+	}, pl.Containers)
+	require.Empty(t, pl.Images)
+	require.Empty(t, docker.mutations())
+
+	// entities that are gone or changed since the plan was written are skipped
+	pl.Containers = append(pl.Containers, plannedItem{ID: "c4", State: "exited"})
+	docker.update(func() { docker.container("c2").State = "running" })
+	summary, skipped, err := apply(dockerClient, pl, purgeOrigin{Rule: "apply"})
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, 1, summary.Containers)
+	require.Equal(t, []skippedItem{
+		{plannedItem: pl.Containers[0], Reason: "it is running now, it was exited when the plan was made"},
+		{plannedItem: pl.Containers[2], Reason: "it does not exist anymore"},
+	}, skipped)
+	require.Equal(t, []string{"DELETE /containers/c3"}, docker.mutations())
+}
+
+func TestPlannedItemChanged(t *testing.T) {
+	require.Empty(t, plannedItem{State: "exited"}.changed("exited", false))
+	require.NotEmpty(t, plannedItem{State: "exited"}.changed("running", true))
+	// plans without states only compare the usage
+	require.Empty(t, plannedItem{}.changed("running", false))
+	require.Equal(t, "it is in use now", plannedItem{}.changed("", true))
+	require.Equal(t, "it is not in use anymore", plannedItem{InUse: true}.changed("", false))
+}
+
+func TestReadPlan(t *testing.T) {
+	dir, removeDir := newTempDir(t)
+	defer removeDir()
+
+	path := filepath.Join(dir, "plan.json")
+	require.Nil(t, ioutil.WriteFile(path, []byte(`{"policy": {"filter": ".IsImage"}, "images": [{"id": "sha256:i1"}]}`), 0644))
+	pl, err := readPlan(path)
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, ".IsImage", pl.Policy.Filter)
+	require.Equal(t, []plannedItem{{ID: "sha256:i1"}}, pl.Images)
+
+	require.Nil(t, ioutil.WriteFile(path, []byte(`{`), 0644))
+	_, err = readPlan(path)
+	require.NotNil(t, err)
+}
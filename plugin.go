@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// pluginName is the name of the docker cli plugin, docker-purge is invoked as `docker purge`
+const pluginName = "purge"
+
+// pluginMetadata is printed for the docker cli when it looks for plugins
+type pluginMetadata struct {
+	SchemaVersion    string `json:"SchemaVersion"`
+	Vendor           string `json:"Vendor"`
+	Version          string `json:"Version,omitempty"`
+	ShortDescription string `json:"ShortDescription,omitempty"`
+	URL              string `json:"URL,omitempty"`
+}
+
+func handlePluginMetadata() {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(pluginMetadata{
+		SchemaVersion:    "0.1.0",
+		Vendor:           "Eun",
+		Version:          Version,
+		ShortDescription: "Purge docker containers, images and networks with jq",
+		URL:              "https://github.com/Eun/docker-purge",
+	})
+}
+
+// isPluginInvocation reports whether docker-purge was started by the docker cli
+func isPluginInvocation() bool {
+	return os.Getenv("DOCKER_CLI_PLUGIN_ORIGINAL_CLI_COMMAND") != ""
+}
+
+// pluginArgs maps the docker cli flags that come before the plugin name onto docker-purge flags.
+// --context, --host and the tls flags are the same, --config sets DOCKER_CONFIG
+// and the logging flags of the docker cli are dropped. The plugin name is dropped if a
+// command follows it.
+func pluginArgs(args []string) []string {
+	var mapped []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == pluginName && i+1 < len(args) && kingpin.CommandLine.GetCommand(args[i+1]) != nil {
+			// docker purge list runs the list command, not purge with the filter list
+			return append(mapped, args[i+1:]...)
+		}
+		if arg == pluginName || !strings.HasPrefix(arg, "-") {
+			return append(mapped, args[i:]...)
+		}
+		name, value, hasValue := arg, "", false
+		if j := strings.Index(arg, "="); j >= 0 {
+			name, value, hasValue = arg[:j], arg[j+1:], true
+		}
+		switch name {
+		case "--config", "-l", "--log-level":
+			if !hasValue && i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+			if name == "--config" {
+				os.Setenv("DOCKER_CONFIG", value)
+			}
+		case "-D", "--debug":
+		case "-c", "--context", "-H", "--host", "--tlscacert", "--tlscert", "--tlskey":
+			mapped = append(mapped, arg)
+			if !hasValue && i+1 < len(args) {
+				i++
+				mapped = append(mapped, args[i])
+			}
+		default:
+			mapped = append(mapped, arg)
+		}
+	}
+	return mapped
+}
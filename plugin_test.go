@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginArgs(t *testing.T) {
+	defer os.Unsetenv("DOCKER_CONFIG")
+
+	require.Equal(t, []string{"purge", ".State == \"exited\""}, pluginArgs([]string{"purge", ".State == \"exited\""}))
+	require.Equal(t,
+		[]string{"--context", "build", "-H", "ssh://build-1", "--tls", "--tlsverify", "purge", "-d", "--config", "daemon.json"},
+		pluginArgs([]string{"--context", "build", "-D", "-l", "debug", "-H", "ssh://build-1", "--tls", "--tlsverify", "--config", "/etc/docker-cli", "purge", "-d", "--config", "daemon.json"}))
+	require.Equal(t, "/etc/docker-cli", os.Getenv("DOCKER_CONFIG"))
+
+	require.Equal(t, []string{"purge"}, pluginArgs([]string{"--config=/tmp/docker", "--log-level=info", "purge"}))
+	require.Equal(t, []string{"list", "--format", "table"}, pluginArgs([]string{"purge", "list", "--format", "table"}))
+	require.Equal(t, "/tmp/docker", os.Getenv("DOCKER_CONFIG"))
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Eun/docker-purge/jq"
+)
+
+// fixtureSuffix is the suffix of the files that hold the fixtures of a policy,
+// the fixtures of policies/old-images.json are in policies/old-images.fixtures.json
+const fixtureSuffix = ".fixtures.json"
+
+// fixture is an entity document with the expectation whether the policy matches it
+type fixture struct {
+	Name     string          `json:"name"`
+	Match    bool            `json:"match"`
+	Document json.RawMessage `json:"document"`
+	// Now is the time older_than and the other helpers compare with, the current time if it is not set
+	Now time.Time `json:"now"`
+}
+
+// policyTest is a policy with its fixtures
+type policyTest struct {
+	Name     string
+	Policy   policy
+	Fixtures []fixture
+}
+
+// policyTestResult is the outcome of running the fixtures of a policy
+type policyTestResult struct {
+	Name string
+	// Missing are the fixtures that should have matched but did not
+	Missing []string
+	// Unexpected are the fixtures that matched but should not have
+	Unexpected []string
+	Errors     []string
+	Fixtures   int
+}
+
+func (r *policyTestResult) failed() bool {
+	return len(r.Missing)+len(r.Unexpected)+len(r.Errors) > 0
+}
+
+// loadPolicyTests reads the policies and fixtures of a directory
+func loadPolicyTests(dir string) ([]*policyTest, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	tests := make(map[string]*policyTest)
+	var fixtureFiles []string
+	for _, file := range files {
+		if strings.HasSuffix(file, fixtureSuffix) {
+			fixtureFiles = append(fixtureFiles, file)
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(file), ".json")
+		pt := policyTest{Name: name}
+		if err := readJSONFile(file, &pt.Policy); err != nil {
+			return nil, err
+		}
+		if !jq.IsValidFilter(pt.Policy.Filter) {
+			return nil, fmt.Errorf("policy %s: invalid filter `%s'", name, pt.Policy.Filter)
+		}
+		warnUnknownFields("policy "+name+": ", pt.Policy.Filter)
+		tests[name] = &pt
+	}
+
+	for _, file := range fixtureFiles {
+		name := strings.TrimSuffix(filepath.Base(file), fixtureSuffix)
+		pt, ok := tests[name]
+		if !ok {
+			return nil, fmt.Errorf("%s has no policy %s.json", file, name)
+		}
+		if err := readJSONFile(file, &pt.Fixtures); err != nil {
+			return nil, err
+		}
+		for i := range pt.Fixtures {
+			if pt.Fixtures[i].Name == "" {
+				pt.Fixtures[i].Name = fmt.Sprintf("fixture-%d", i+1)
+			}
+		}
+	}
+
+	var result []*policyTest
+	for _, file := range files {
+		if pt, ok := tests[strings.TrimSuffix(filepath.Base(file), ".json")]; ok {
+			result = append(result, pt)
+		}
+	}
+	return result, nil
+}
+
+func readJSONFile(path string, v interface{}) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(buf, v); err != nil {
+		return fmt.Errorf("unable to parse %s: %s", path, err.Error())
+	}
+	return nil
+}
+
+// appliesTo reports whether the policy purges entities of the kind of the document
+func (p policy) appliesTo(document json.RawMessage) (bool, error) {
+	var kind struct {
+		IsContainer bool
+		IsImage     bool
+		IsNetwork   bool
+	}
+	if err := json.Unmarshal(document, &kind); err != nil {
+		return false, err
+	}
+	if !p.Containers && !p.Images && !p.Networks {
+		return true, nil
+	}
+	return kind.IsContainer && p.Containers || kind.IsImage && p.Images || kind.IsNetwork && p.Networks, nil
+}
+
+// run runs the policy against its fixtures
+func (pt *policyTest) run() *policyTestResult {
+	result := policyTestResult{Name: pt.Name, Fixtures: len(pt.Fixtures)}
+	defer func(now func() time.Time) { jq.Now = now }(jq.Now)
+	wallClock := jq.Now
+	for _, f := range pt.Fixtures {
+		jq.Now = wallClock
+		if !f.Now.IsZero() {
+			now := f.Now
+			jq.Now = func() time.Time { return now }
+		}
+		matched, err := pt.Policy.appliesTo(f.Document)
+		if err == nil && matched {
+			matched, err = jq.MatchesFilter(string(f.Document), pt.Policy.Filter)
+		}
+		switch {
+		case err != nil:
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %s", f.Name, err.Error()))
+		case f.Match && !matched:
+			result.Missing = append(result.Missing, f.Name)
+		case !f.Match && matched:
+			result.Unexpected = append(result.Unexpected, f.Name)
+		}
+	}
+	return &result
+}
+
+// writePolicyTestReport writes the results like go test does,
+// the fixtures that failed are written as a diff of the expected and the actual matches
+func writePolicyTestReport(w io.Writer, results []*policyTestResult) (failed bool) {
+	for _, r := range results {
+		switch {
+		case r.Fixtures == 0:
+			fmt.Fprintf(w, "?\t%s\t[no fixtures]\n", r.Name)
+		case !r.failed():
+			fmt.Fprintf(w, "ok\t%s\t%d fixtures\n", r.Name, r.Fixtures)
+		default:
+			failed = true
+			fmt.Fprintf(w, "FAIL\t%s\t%d of %d fixtures failed\n", r.Name, len(r.Missing)+len(r.Unexpected)+len(r.Errors), r.Fixtures)
+			if len(r.Missing)+len(r.Unexpected) > 0 {
+				fmt.Fprintln(w, "\t--- expected matches")
+				fmt.Fprintln(w, "\t+++ actual matches")
+				for _, name := range r.Missing {
+					fmt.Fprintf(w, "\t-%s\n", name)
+				}
+				for _, name := range r.Unexpected {
+					fmt.Fprintf(w, "\t+%s\n", name)
+				}
+			}
+			for _, e := range r.Errors {
+				fmt.Fprintf(w, "\terror: %s\n", e)
+			}
+		}
+	}
+	if failed {
+		fmt.Fprintln(w, "FAIL")
+	} else {
+		fmt.Fprintln(w, "PASS")
+	}
+	return failed
+}
+
+func handleTest() {
+	tests, err := loadPolicyTests(*testDirArg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if len(tests) == 0 {
+		fmt.Fprintf(os.Stderr, "no policies found in %s\n", *testDirArg)
+		os.Exit(1)
+	}
+	var results []*policyTestResult
+	for _, pt := range tests {
+		results = append(results, pt.run())
+	}
+	if writePolicyTestReport(os.Stdout, results) {
+		os.Exit(1)
+	}
+}
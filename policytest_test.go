@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		require.Nil(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+}
+
+func TestPolicyTests(t *testing.T) {
+	dir, removeDir := newTempDir(t)
+	defer removeDir()
+
+	writeTestFiles(t, dir, map[string]string{
+		"exited.json": `{"filter": ".State == \"exited\"", "containers": true}`,
+		"exited.fixtures.json": `[
+			{"name": "exited", "match": true, "document": {"IsContainer": true, "State": "exited"}},
+			{"name": "running", "match": false, "document": {"IsContainer": true, "State": "running"}},
+			{"name": "image", "match": false, "document": {"IsImage": true, "State": "exited"}}
+		]`,
+		"dangling.json": `{"filter": ".RepoTags == [\"<none>:<none>\"]"}`,
+		"dangling.fixtures.json": `[
+			{"name": "dangling", "match": true, "document": {"IsImage": true, "RepoTags": ["<none>:<none>"]}},
+			{"name": "tagged", "match": true, "document": {"IsImage": true, "RepoTags": ["firefox:latest"]}},
+			{"match": false, "document": {"IsImage": true, "RepoTags": ["<none>:<none>"]}}
+		]`,
+		"untested.json": `{"filter": ".IsNetwork"}`,
+	})
+
+	tests, err := loadPolicyTests(dir)
+	require.Nil(t, err, "Expected no Error")
+	require.Len(t, tests, 3)
+
+	var results []*policyTestResult
+	for _, pt := range tests {
+		results = append(results, pt.run())
+	}
+	require.Equal(t, "dangling", results[0].Name)
+	require.Equal(t, []string{"tagged"}, results[0].Missing)
+	require.Equal(t, []string{"fixture-3"}, results[0].Unexpected)
+	require.False(t, results[1].failed())
+
+	var report bytes.Buffer
+	require.True(t, writePolicyTestReport(&report, results))
+	require.Equal(t, "FAIL\tdangling\t2 of 3 fixtures failed\n"+
+		"\t--- expected matches\n"+
+		"\t+++ actual matches\n"+
+		"\t-tagged\n"+
+		"\t+fixture-3\n"+
+		"ok\texited\t3 fixtures\n"+
+		"?\tuntested\t[no fixtures]\n"+
+		"FAIL\n", report.String())
+
+	writeTestFiles(t, dir, map[string]string{"orphan.fixtures.json": `[]`})
+	_, err = loadPolicyTests(dir)
+	require.NotNil(t, err)
+}
+
+func TestPolicyTestsNow(t *testing.T) {
+	dir, removeDir := newTempDir(t)
+	defer removeDir()
+
+	// created 2017-07-14T02:40:00Z
+	writeTestFiles(t, dir, map[string]string{
+		"old.json": `{"filter": "older_than(\"7d\")", "images": true}`,
+		"old.fixtures.json": `[
+			{"name": "a day old", "match": false, "now": "2017-07-15T02:40:00Z", "document": {"IsImage": true, "Created": 1500000000}},
+			{"name": "a month old", "match": true, "now": "2017-08-14T02:40:00Z", "document": {"IsImage": true, "Created": 1500000000}},
+			{"name": "wall clock", "match": true, "document": {"IsImage": true, "Created": 1500000000}}
+		]`,
+	})
+
+	tests, err := loadPolicyTests(dir)
+	require.Nil(t, err, "Expected no Error")
+	require.Len(t, tests, 1)
+	result := tests[0].run()
+	require.False(t, result.failed(), "%+v", result)
+
+	writeTestFiles(t, dir, map[string]string{"old.fixtures.json": `[{"match": true, "now": "yesterday", "document": {}}]`})
+	_, err = loadPolicyTests(dir)
+	require.NotNil(t, err)
+}
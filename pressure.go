@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	units "github.com/docker/go-units"
+)
+
+// diskStats holds the usage of a filesystem
+type diskStats struct {
+	FreeBytes   uint64
+	TotalBytes  uint64
+	FreeInodes  uint64
+	TotalInodes uint64
+}
+
+// threshold is either an absolute amount or a percentage of the total
+type threshold struct {
+	amount  uint64
+	percent float64
+}
+
+// parseThreshold parses a threshold like 10GB or 15%, without percent sign
+// the amount is parsed with parseAmount
+func parseThreshold(s string, parseAmount func(string) (uint64, error)) (*threshold, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	if strings.HasSuffix(s, "%") {
+		p, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || p < 0 || p > 100 {
+			return nil, fmt.Errorf("invalid percentage `%s'", s)
+		}
+		return &threshold{percent: p}, nil
+	}
+	n, err := parseAmount(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid threshold `%s': %s", s, err.Error())
+	}
+	return &threshold{amount: n}, nil
+}
+
+func parseBytes(s string) (uint64, error) {
+	n, err := units.FromHumanSize(s)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("negative size")
+	}
+	return uint64(n), nil
+}
+
+func parseCount(s string) (uint64, error) {
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// value returns the absolute amount of the threshold
+func (t *threshold) value(total uint64) uint64 {
+	if t.percent > 0 {
+		return uint64(float64(total) * t.percent / 100)
+	}
+	return t.amount
+}
+
+// pressureConfig configures when an emergency policy is run
+type pressureConfig struct {
+	// MinFree is the free space (e.g. 10GB or 10%) below which the emergency policy is run
+	MinFree string `json:"minFree"`
+	// MinFreeInodes is the number of free inodes (e.g. 100000 or 5%) below which the emergency policy is run
+	MinFreeInodes string `json:"minFreeInodes"`
+	// HighWater is the free space the emergency policy runs until, defaults to MinFree
+	HighWater string `json:"highWater"`
+	// HighWaterInodes is the number of free inodes the emergency policy runs until, defaults to MinFreeInodes
+	HighWaterInodes string `json:"highWaterInodes"`
+	// Interval is the time between two checks
+	Interval string `json:"interval"`
+	// Path overrides the DockerRootDir reported by docker, useful if docker-purge runs in a container
+	Path string `json:"path"`
+	// Policy is the emergency policy to run
+	Policy policy `json:"policy"`
+
+	minFree, minFreeInodes, highWater, highWaterInodes *threshold
+	interval                                           time.Duration
+}
+
+// parse validates the config, it returns nil if no threshold is configured
+func (c *pressureConfig) parse() (*pressureConfig, error) {
+	if c == nil || (c.MinFree == "" && c.MinFreeInodes == "") {
+		return nil, nil
+	}
+	if strings.TrimSpace(c.Policy.Filter) == "" {
+		return nil, fmt.Errorf("the emergency policy requires a filter, an empty filter matches every entity")
+	}
+	var err error
+	if c.minFree, err = parseThreshold(c.MinFree, parseBytes); err != nil {
+		return nil, err
+	}
+	if c.minFreeInodes, err = parseThreshold(c.MinFreeInodes, parseCount); err != nil {
+		return nil, err
+	}
+	if c.highWater, err = parseThreshold(c.HighWater, parseBytes); err != nil {
+		return nil, err
+	}
+	if c.highWater == nil {
+		c.highWater = c.minFree
+	}
+	if c.highWaterInodes, err = parseThreshold(c.HighWaterInodes, parseCount); err != nil {
+		return nil, err
+	}
+	if c.highWaterInodes == nil {
+		c.highWaterInodes = c.minFreeInodes
+	}
+	c.interval = time.Minute
+	if c.Interval != "" {
+		if c.interval, err = time.ParseDuration(c.Interval); err != nil {
+			return nil, fmt.Errorf("invalid interval `%s': %s", c.Interval, err.Error())
+		}
+	}
+	return c, nil
+}
+
+// underPressure reports whether the free space or inodes are below the thresholds
+func underPressure(stats *diskStats, space, inodes *threshold) bool {
+	if space != nil && stats.FreeBytes < space.value(stats.TotalBytes) {
+		return true
+	}
+	if inodes != nil && stats.TotalInodes > 0 && stats.FreeInodes < inodes.value(stats.TotalInodes) {
+		return true
+	}
+	return false
+}
+
+// watchPressure checks the filesystem docker stores its data on every interval
+// and runs the emergency policy if the free space drops below the threshold,
+// it returns when the context is done
+func watchPressure(ctx context.Context, dockerClient *client.Client, config *pressureConfig, origin purgeOrigin) {
+	path := config.Path
+	if path == "" {
+		info, err := dockerClient.Info(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to get docker root dir, disk pressure detection disabled: %s\n", err.Error())
+			return
+		}
+		path = info.DockerRootDir
+	}
+
+	ticker := time.NewTicker(config.interval)
+	defer ticker.Stop()
+	for {
+		if err := relievePressure(dockerClient, config, path, origin); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relievePressure runs the emergency policy until the free space is above the high water mark
+// or the policy does not delete anything anymore
+func relievePressure(dockerClient *client.Client, config *pressureConfig, path string, origin purgeOrigin) error {
+	stats, err := diskFree(path)
+	if err != nil {
+		return fmt.Errorf("unable to get free space of %s: %s", path, err.Error())
+	}
+	if !underPressure(stats, config.minFree, config.minFreeInodes) {
+		return nil
+	}
+	fmt.Fprintf(os.Stdout, "disk pressure on %s: %s free, %d inodes free, running emergency policy\n",
+		path, units.HumanSize(float64(stats.FreeBytes)), stats.FreeInodes)
+
+	// trashing does not free any space
+	p := config.Policy
+	p.Trash = false
+	origin.Rule = "pressure"
+	for underPressure(stats, config.highWater, config.highWaterInodes) {
+		summary, err := runPurge(dockerClient, p, origin)
+		if err != nil {
+			return fmt.Errorf("emergency policy failed: %s", err.Error())
+		}
+		if config.Policy.Dry || summary.Containers+summary.Images+summary.Networks == 0 {
+			fmt.Fprintf(os.Stderr, "emergency policy cannot free any more space on %s\n", path)
+			return nil
+		}
+		if stats, err = diskFree(path); err != nil {
+			return fmt.Errorf("unable to get free space of %s: %s", path, err.Error())
+		}
+	}
+	fmt.Fprintf(os.Stdout, "disk pressure on %s relieved: %s free, %d inodes free\n",
+		path, units.HumanSize(float64(stats.FreeBytes)), stats.FreeInodes)
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnderPressure(t *testing.T) {
+	stats := &diskStats{
+		FreeBytes:   5 * 1000 * 1000 * 1000,
+		TotalBytes:  100 * 1000 * 1000 * 1000,
+		FreeInodes:  20000,
+		TotalInodes: 100000,
+	}
+	tests := []struct {
+		Space    string
+		Inodes   string
+		Pressure bool
+	}{
+		{"10GB", "", true},
+		{"1GB", "", false},
+		{"10%", "", true},
+		{"5%", "", false},
+		{"", "50000", true},
+		{"", "10%", false},
+		{"1GB", "25%", true},
+	}
+	for _, test := range tests {
+		space, err := parseThreshold(test.Space, parseBytes)
+		require.Nil(t, err, "Expected no Error")
+		inodes, err := parseThreshold(test.Inodes, parseCount)
+		require.Nil(t, err, "Expected no Error")
+		require.Equal(t, test.Pressure, underPressure(stats, space, inodes), "%s %s", test.Space, test.Inodes)
+	}
+
+	_, err := parseThreshold("110%", parseBytes)
+	require.NotNil(t, err, "Expected Error")
+	_, err = parseThreshold("lots", parseCount)
+	require.NotNil(t, err, "Expected Error")
+}
+
+func TestPressureConfigRequiresFilter(t *testing.T) {
+	config, err := (&pressureConfig{}).parse()
+	require.Nil(t, err, "Expected no Error")
+	require.Nil(t, config)
+
+	_, err = (&pressureConfig{MinFree: "10%"}).parse()
+	require.NotNil(t, err, "Expected Error")
+	_, err = (&pressureConfig{MinFreeInodes: "5%", Policy: policy{Filter: " "}}).parse()
+	require.NotNil(t, err, "Expected Error")
+
+	config, err = (&pressureConfig{MinFree: "10%", Policy: policy{Filter: `.State == "exited"`}}).parse()
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, config.minFree, config.highWater)
+}
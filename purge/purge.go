@@ -0,0 +1,576 @@
+// Package purge selects docker containers, images and networks with jq filters and deletes them.
+//
+// It is the engine behind the docker-purge command line tool and can be embedded in other tools:
+//
+//	dockerClient, _ := client.NewEnvClient()
+//	summary, err := purge.Run(context.Background(), dockerClient, purge.Options{
+//		Filter:     `.State == "exited"`,
+//		Containers: true,
+//	})
+package purge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Eun/docker-purge/jq"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// Docker is the part of the docker api docker-purge needs to select and delete entities,
+// it is satisfied by client.CommonAPIClient
+type Docker interface {
+	ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error)
+	ContainerInspect(ctx context.Context, container string) (types.ContainerJSON, error)
+	ContainerKill(ctx context.Context, container, signal string) error
+	ContainerStop(ctx context.Context, container string, timeout *time.Duration) error
+	ContainerRemove(ctx context.Context, container string, options types.ContainerRemoveOptions) error
+	ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error)
+	ImageRemove(ctx context.Context, image string, options types.ImageRemoveOptions) ([]types.ImageDelete, error)
+	NetworkList(ctx context.Context, options types.NetworkListOptions) ([]types.NetworkResource, error)
+	NetworkRemove(ctx context.Context, network string) error
+}
+
+// Container is the document of a container the filters operate on
+type Container struct {
+	IsImage     bool
+	IsContainer bool
+	IsNetwork   bool
+	types.Container
+	Common
+	Name string // first name without the leading slash
+}
+
+// Image is the document of an image the filters operate on
+type Image struct {
+	IsImage     bool
+	IsContainer bool
+	IsNetwork   bool
+	types.ImageSummary
+	Common
+	Name     string // first tag, empty for dangling images
+	LastUsed int64  // unix time the image was last used by a container, see Options.ImageLastUsed
+}
+
+// Network is the document of a network the filters operate on
+type Network struct {
+	IsImage     bool
+	IsContainer bool
+	IsNetwork   bool
+	types.NetworkResource
+	Common
+	Created int64 // override NetworkResource Created
+}
+
+// Common holds the fields every document has, so a single filter can apply to all kinds.
+// Name, Created and Labels are uniform too, they are part of the documents of every kind.
+type Common struct {
+	Kind       string // container, image or network
+	CreatedAt  string // Created in RFC3339
+	AgeSeconds int64  // seconds since the entity was created
+	SizeBytes  int64  // size of the writable layer of containers, size of images, 0 for networks
+	InUse      bool   // containers are running, images and networks are used by a container
+}
+
+func newCommon(kind string, created int64, size int64, inUse bool, now time.Time) Common {
+	return Common{
+		Kind:       kind,
+		CreatedAt:  time.Unix(created, 0).UTC().Format(time.RFC3339),
+		AgeSeconds: now.Unix() - created,
+		SizeBytes:  size,
+		InUse:      inUse,
+	}
+}
+
+// NewContainer returns the document of a container
+func NewContainer(c types.Container, now time.Time) Container {
+	inUse := c.State == "running" || c.State == "paused" || c.State == "restarting"
+	doc := Container{
+		IsContainer: true,
+		Container:   c,
+		Common:      newCommon("container", c.Created, c.SizeRw, inUse, now),
+	}
+	if len(c.Names) > 0 {
+		doc.Name = strings.TrimPrefix(c.Names[0], "/")
+	}
+	return doc
+}
+
+// NewImage returns the document of an image, containers are all containers of the host
+func NewImage(i types.ImageSummary, containers []types.Container, now time.Time) Image {
+	inUse := false
+	for _, c := range containers {
+		if c.ImageID == i.ID {
+			inUse = true
+			break
+		}
+	}
+	doc := Image{
+		IsImage:      true,
+		ImageSummary: i,
+		Common:       newCommon("image", i.Created, i.Size, inUse, now),
+	}
+	for _, tag := range i.RepoTags {
+		if tag != "<none>:<none>" {
+			doc.Name = tag
+			break
+		}
+	}
+	return doc
+}
+
+// NewNetwork returns the document of a network, containers are all containers of the host
+func NewNetwork(n types.NetworkResource, containers []types.Container, now time.Time) Network {
+	inUse := len(n.Containers) > 0
+	for _, c := range containers {
+		if c.NetworkSettings == nil {
+			continue
+		}
+		for _, endpoint := range c.NetworkSettings.Networks {
+			if endpoint != nil && endpoint.NetworkID == n.ID {
+				inUse = true
+			}
+		}
+	}
+	return Network{
+		IsNetwork:       true,
+		NetworkResource: n,
+		Common:          newCommon("network", n.Created.Unix(), 0, inUse, now),
+		Created:         n.Created.Unix(),
+	}
+}
+
+// Options configures which entities are selected and how they are deleted
+type Options struct {
+	// Filter is the jq filter entities have to match, an empty filter matches everything
+	Filter string
+	// Containers, Images and Networks limit the purge to these kinds, if none is set all kinds are purged
+	Containers bool
+	Images     bool
+	Networks   bool
+	// Dry reports the entities without deleting them
+	Dry bool
+
+	ContainerRemoveOptions types.ContainerRemoveOptions
+	ImageRemoveOptions     types.ImageRemoveOptions
+	// KillSignal kills running containers with this signal before they are removed
+	KillSignal string
+	// Stop stops running containers before they are removed
+	Stop bool
+
+	// ImageLastUsed returns the unix time an image was last used, it fills Image.LastUsed
+	ImageLastUsed func(id string) int64
+	// Skip excludes entities from the deletion, they are not reported either
+	Skip func(kind, id string) bool
+	// BeforeRemoveContainer and BeforeRemoveImage are called before an entity is removed,
+	// the entity is kept if they return an error
+	BeforeRemoveContainer func(c Container) error
+	BeforeRemoveImage     func(i Image) error
+	// RemoveContainer and RemoveImage replace the removal of an entity,
+	// for example to move it somewhere else instead of deleting it
+	RemoveContainer func(c Container) error
+	RemoveImage     func(i Image) error
+	// Report is called with the result of every entity, also in dry mode
+	Report func(result Result, labels map[string]string)
+}
+
+// Summary holds the number of entities a purge deleted,
+// in dry mode the number of entities that would have been deleted
+type Summary struct {
+	Containers int
+	Images     int
+	Networks   int
+	Failed     int
+	Results    []Result
+}
+
+// Result is the result of purging a single entity
+type Result struct {
+	Kind  string
+	ID    string
+	Names []string `json:",omitempty"`
+	Error string   `json:",omitempty"`
+}
+
+// Add adds the results to the summary
+func (s *Summary) Add(results []Result) {
+	for _, result := range results {
+		if result.Error != "" {
+			s.Failed++
+			continue
+		}
+		switch result.Kind {
+		case "container":
+			s.Containers++
+		case "image":
+			s.Images++
+		case "network":
+			s.Networks++
+		}
+	}
+	s.Results = append(s.Results, results...)
+}
+
+var containerListOptions = types.ContainerListOptions{
+	All: true,
+}
+
+var imageListOptions = types.ImageListOptions{
+	All: true,
+}
+
+var networkListOptions = types.NetworkListOptions{}
+
+// Run deletes all entities that match the options
+func Run(ctx context.Context, docker Docker, opts Options) (*Summary, error) {
+	var summary Summary
+
+	if !opts.Containers && !opts.Images && !opts.Networks {
+		opts.Containers = true
+		opts.Images = true
+		opts.Networks = true
+	}
+
+	if opts.Containers {
+		containers, err := SelectContainers(ctx, docker, opts.Filter)
+		if err != nil {
+			return nil, err
+		}
+		summary.Add(DeleteContainers(ctx, docker, containers, opts))
+	}
+
+	if opts.Images {
+		images, err := SelectImages(ctx, docker, opts.Filter, opts.ImageLastUsed)
+		if err != nil {
+			return nil, err
+		}
+		summary.Add(DeleteImages(ctx, docker, images, opts))
+	}
+
+	if opts.Networks {
+		networks, err := SelectNetworks(ctx, docker, opts.Filter)
+		if err != nil {
+			return nil, err
+		}
+		summary.Add(DeleteNetworks(ctx, docker, networks, opts))
+	}
+	return &summary, nil
+}
+
+// Matches reports whether the document of the entity matches the filter
+func Matches(entity interface{}, filter string) (bool, error) {
+	if filter == "" {
+		return true, nil
+	}
+	buf, err := json.Marshal(entity)
+	if err != nil {
+		return false, err
+	}
+	return jq.MatchesFilter(string(buf), filter)
+}
+
+// SelectContainers returns all containers that match the filter,
+// the sizes of the containers are only calculated if the filter references a size, it is slow
+func SelectContainers(ctx context.Context, docker Docker, filter string) ([]Container, error) {
+	return selectContainers(ctx, docker, filter, strings.Contains(filter, "Size"))
+}
+
+// ListContainers returns all containers with their sizes, e.g. to evaluate filters against them later
+func ListContainers(ctx context.Context, docker Docker) ([]Container, error) {
+	return selectContainers(ctx, docker, "", true)
+}
+
+func selectContainers(ctx context.Context, docker Docker, filter string, size bool) ([]Container, error) {
+	options := containerListOptions
+	options.Size = size
+	entities, err := docker.ContainerList(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var selected []Container
+	for _, e := range entities {
+		c := NewContainer(e, now)
+		ok, err := Matches(c, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			selected = append(selected, c)
+		}
+	}
+	return selected, nil
+}
+
+// SelectImages returns all images that match the filter, lastUsed fills Image.LastUsed and may be nil
+func SelectImages(ctx context.Context, docker Docker, filter string, lastUsed func(id string) int64) ([]Image, error) {
+	entities, err := docker.ImageList(ctx, imageListOptions)
+	if err != nil {
+		return nil, err
+	}
+	containers, err := docker.ContainerList(ctx, containerListOptions)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var selected []Image
+	for _, e := range entities {
+		i := NewImage(e, containers, now)
+		if lastUsed != nil {
+			i.LastUsed = lastUsed(e.ID)
+		}
+		ok, err := Matches(i, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			selected = append(selected, i)
+		}
+	}
+	return selected, nil
+}
+
+// SelectNetworks returns all networks that match the filter
+func SelectNetworks(ctx context.Context, docker Docker, filter string) ([]Network, error) {
+	entities, err := docker.NetworkList(ctx, networkListOptions)
+	if err != nil {
+		return nil, err
+	}
+	containers, err := docker.ContainerList(ctx, containerListOptions)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var selected []Network
+	for _, e := range entities {
+		n := NewNetwork(e, containers, now)
+		ok, err := Matches(n, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			selected = append(selected, n)
+		}
+	}
+	return selected, nil
+}
+
+// SelectContainer returns the container with the id if it matches the filter, nil otherwise.
+// Only the document of that container is evaluated, so it is cheap to call for single events.
+func SelectContainer(ctx context.Context, docker Docker, id, filter string) (*Container, error) {
+	options := containerListOptions
+	options.Size = strings.Contains(filter, "Size")
+	options.Filters = filters.NewArgs()
+	options.Filters.Add("id", id)
+	entities, err := docker.ContainerList(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entities {
+		if e.ID != id {
+			continue
+		}
+		c := NewContainer(e, time.Now())
+		ok, err := Matches(c, filter)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return &c, nil
+	}
+	return nil, nil
+}
+
+// SelectImage returns the image with the id if it matches the filter, nil otherwise,
+// lastUsed fills Image.LastUsed and may be nil
+func SelectImage(ctx context.Context, docker Docker, id, filter string, lastUsed func(id string) int64) (*Image, error) {
+	entities, err := docker.ImageList(ctx, imageListOptions)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entities {
+		if e.ID != id {
+			continue
+		}
+		options := containerListOptions
+		options.Filters = filters.NewArgs()
+		options.Filters.Add("ancestor", id)
+		containers, err := docker.ContainerList(ctx, options)
+		if err != nil {
+			return nil, err
+		}
+		i := NewImage(e, containers, time.Now())
+		if lastUsed != nil {
+			i.LastUsed = lastUsed(e.ID)
+		}
+		ok, err := Matches(i, filter)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return &i, nil
+	}
+	return nil, nil
+}
+
+// SelectNetwork returns the network with the id if it matches the filter, nil otherwise
+func SelectNetwork(ctx context.Context, docker Docker, id, filter string) (*Network, error) {
+	options := networkListOptions
+	options.Filters = filters.NewArgs()
+	options.Filters.Add("id", id)
+	entities, err := docker.NetworkList(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entities {
+		if e.ID != id {
+			continue
+		}
+		containerOptions := containerListOptions
+		containerOptions.Filters = filters.NewArgs()
+		containerOptions.Filters.Add("network", id)
+		containers, err := docker.ContainerList(ctx, containerOptions)
+		if err != nil {
+			return nil, err
+		}
+		n := NewNetwork(e, containers, time.Now())
+		ok, err := Matches(n, filter)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return &n, nil
+	}
+	return nil, nil
+}
+
+func report(opts Options, result Result, labels map[string]string) {
+	if opts.Report != nil {
+		opts.Report(result, labels)
+	}
+}
+
+func skip(opts Options, kind, id string) bool {
+	return opts.Skip != nil && opts.Skip(kind, id)
+}
+
+// DeleteContainers deletes the containers, running containers are killed and/or stopped first
+// if the options say so
+func DeleteContainers(ctx context.Context, docker Docker, containers []Container, opts Options) []Result {
+	results := make([]Result, 0, len(containers))
+	for _, c := range containers {
+		if skip(opts, "container", c.ID) {
+			continue
+		}
+		result := Result{Kind: "container", ID: c.ID, Names: c.Names}
+		if !opts.Dry {
+			var err error
+			if opts.RemoveContainer != nil {
+				err = opts.RemoveContainer(c)
+			} else {
+				err = deleteContainer(ctx, docker, c, opts)
+			}
+			if err != nil {
+				result.Error = err.Error()
+			}
+		}
+		report(opts, result, c.Labels)
+		results = append(results, result)
+	}
+	return results
+}
+
+func deleteContainer(ctx context.Context, docker Docker, c Container, opts Options) error {
+	if opts.BeforeRemoveContainer != nil {
+		if err := opts.BeforeRemoveContainer(c); err != nil {
+			return err
+		}
+	}
+	if opts.KillSignal != "" || opts.Stop {
+		details, err := docker.ContainerInspect(ctx, c.ID)
+		if err != nil {
+			return fmt.Errorf("unable to inspect container %s: %s", c.ID, err.Error())
+		}
+		if details.State.Running {
+			if err := StopContainer(ctx, docker, c.ID, opts.KillSignal, opts.Stop); err != nil {
+				return err
+			}
+		}
+	}
+	if err := docker.ContainerRemove(ctx, c.ID, opts.ContainerRemoveOptions); err != nil {
+		return fmt.Errorf("unable to delete container %s: %s", c.ID, err.Error())
+	}
+	return nil
+}
+
+// StopContainer kills the container with the signal if it is not empty and stops it if stop is set
+func StopContainer(ctx context.Context, docker Docker, id string, signal string, stop bool) error {
+	if signal != "" {
+		if err := docker.ContainerKill(ctx, id, signal); err != nil {
+			return fmt.Errorf("unable to kill container %s: %s", id, err.Error())
+		}
+	}
+	if stop {
+		if err := docker.ContainerStop(ctx, id, nil); err != nil {
+			return fmt.Errorf("unable to stop container %s: %s", id, err.Error())
+		}
+	}
+	return nil
+}
+
+// DeleteImages deletes the images
+func DeleteImages(ctx context.Context, docker Docker, images []Image, opts Options) []Result {
+	results := make([]Result, 0, len(images))
+	for _, i := range images {
+		if skip(opts, "image", i.ID) {
+			continue
+		}
+		result := Result{Kind: "image", ID: i.ID, Names: i.RepoTags}
+		if !opts.Dry {
+			var err error
+			if opts.RemoveImage != nil {
+				err = opts.RemoveImage(i)
+			} else {
+				err = deleteImage(ctx, docker, i, opts)
+			}
+			if err != nil {
+				result.Error = err.Error()
+			}
+		}
+		report(opts, result, i.Labels)
+		results = append(results, result)
+	}
+	return results
+}
+
+func deleteImage(ctx context.Context, docker Docker, i Image, opts Options) error {
+	if opts.BeforeRemoveImage != nil {
+		if err := opts.BeforeRemoveImage(i); err != nil {
+			return err
+		}
+	}
+	if _, err := docker.ImageRemove(ctx, i.ID, opts.ImageRemoveOptions); err != nil {
+		return fmt.Errorf("unable to delete image %s: %s", i.ID, err.Error())
+	}
+	return nil
+}
+
+// DeleteNetworks deletes the networks
+func DeleteNetworks(ctx context.Context, docker Docker, networks []Network, opts Options) []Result {
+	results := make([]Result, 0, len(networks))
+	for _, n := range networks {
+		if skip(opts, "network", n.ID) {
+			continue
+		}
+		result := Result{Kind: "network", ID: n.ID, Names: []string{n.Name}}
+		if !opts.Dry {
+			if err := docker.NetworkRemove(ctx, n.ID); err != nil {
+				result.Error = fmt.Sprintf("unable to delete network %s: %s", n.ID, err.Error())
+			}
+		}
+		report(opts, result, n.Labels)
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,210 @@
+package purge
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/stretchr/testify/require"
+)
+
+// mockDocker serves fixed entities and records the calls that change something
+type mockDocker struct {
+	containers []types.Container
+	images     []types.ImageSummary
+	networks   []types.NetworkResource
+	running    map[string]bool
+	failRemove map[string]bool
+	calls      []string
+}
+
+func (m *mockDocker) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	return m.containers, nil
+}
+
+func (m *mockDocker) ContainerInspect(ctx context.Context, id string) (types.ContainerJSON, error) {
+	var details types.ContainerJSON
+	details.ContainerJSONBase = &types.ContainerJSONBase{ID: id, State: &types.ContainerState{Running: m.running[id]}}
+	return details, nil
+}
+
+func (m *mockDocker) ContainerKill(ctx context.Context, id, signal string) error {
+	m.calls = append(m.calls, "kill "+id+" "+signal)
+	return nil
+}
+
+func (m *mockDocker) ContainerStop(ctx context.Context, id string, timeout *time.Duration) error {
+	m.calls = append(m.calls, "stop "+id)
+	return nil
+}
+
+func (m *mockDocker) ContainerRemove(ctx context.Context, id string, options types.ContainerRemoveOptions) error {
+	return m.remove("container", id)
+}
+
+func (m *mockDocker) ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
+	return m.images, nil
+}
+
+func (m *mockDocker) ImageRemove(ctx context.Context, id string, options types.ImageRemoveOptions) ([]types.ImageDelete, error) {
+	return nil, m.remove("image", id)
+}
+
+func (m *mockDocker) NetworkList(ctx context.Context, options types.NetworkListOptions) ([]types.NetworkResource, error) {
+	return m.networks, nil
+}
+
+func (m *mockDocker) NetworkRemove(ctx context.Context, id string) error {
+	return m.remove("network", id)
+}
+
+func (m *mockDocker) remove(kind, id string) error {
+	if m.failRemove[id] {
+		return fmt.Errorf("conflict")
+	}
+	m.calls = append(m.calls, "remove "+kind+" "+id)
+	return nil
+}
+
+func newMockDocker() *mockDocker {
+	return &mockDocker{
+		containers: []types.Container{
+			{ID: "c1", Names: []string{"/firefox"}, State: "running"},
+			{ID: "c2", Names: []string{"/chrome"}, State: "exited"},
+		},
+		images: []types.ImageSummary{
+			{ID: "sha256:i1", RepoTags: []string{"firefox:latest"}},
+		},
+		networks: []types.NetworkResource{
+			{ID: "n1", Name: "bridge", Created: time.Unix(1500000000, 0)},
+		},
+		running:    map[string]bool{"c1": true},
+		failRemove: map[string]bool{},
+	}
+}
+
+func TestRunFilter(t *testing.T) {
+	docker := newMockDocker()
+	summary, err := Run(context.Background(), docker, Options{Filter: `.IsContainer == true and .State == "exited"`})
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, 1, summary.Containers)
+	require.Equal(t, 0, summary.Images)
+	require.Equal(t, 0, summary.Networks)
+	require.Equal(t, []string{"remove container c2"}, docker.calls)
+
+	// the network document has a unix timestamp instead of a time
+	networks, err := SelectNetworks(context.Background(), docker, ".Created == 1500000000")
+	require.Nil(t, err, "Expected no Error")
+	require.Len(t, networks, 1)
+
+	images, err := SelectImages(context.Background(), docker, ".LastUsed > 0", func(id string) int64 { return 1 })
+	require.Nil(t, err, "Expected no Error")
+	require.Len(t, images, 1)
+
+	_, err = Run(context.Background(), docker, Options{Filter: ".IsContainer =="})
+	require.NotNil(t, err)
+}
+
+func TestRunDry(t *testing.T) {
+	docker := newMockDocker()
+	var reported []string
+	summary, err := Run(context.Background(), docker, Options{
+		Dry: true,
+		Report: func(result Result, labels map[string]string) {
+			reported = append(reported, result.ID)
+		},
+	})
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, 2, summary.Containers)
+	require.Equal(t, 1, summary.Images)
+	require.Equal(t, 1, summary.Networks)
+	require.Equal(t, []string{"c1", "c2", "sha256:i1", "n1"}, reported)
+	require.Empty(t, docker.calls)
+}
+
+func TestDeleteContainersStopKill(t *testing.T) {
+	docker := newMockDocker()
+	containers, err := SelectContainers(context.Background(), docker, "")
+	require.Nil(t, err, "Expected no Error")
+
+	results := DeleteContainers(context.Background(), docker, containers, Options{KillSignal: "SIGINT", Stop: true})
+	require.Len(t, results, 2)
+	require.Equal(t, []string{"kill c1 SIGINT", "stop c1", "remove container c1", "remove container c2"}, docker.calls)
+
+	// without kill or stop the running container is removed as is, docker refuses unless forced
+	docker = newMockDocker()
+	docker.failRemove["c1"] = true
+	results = DeleteContainers(context.Background(), docker, containers, Options{})
+	require.Equal(t, "unable to delete container c1: conflict", results[0].Error)
+	require.Equal(t, "", results[1].Error)
+	require.Equal(t, []string{"remove container c2"}, docker.calls)
+}
+
+func TestDeleteHooks(t *testing.T) {
+	docker := newMockDocker()
+	images, err := SelectImages(context.Background(), docker, "", nil)
+	require.Nil(t, err, "Expected no Error")
+
+	results := DeleteImages(context.Background(), docker, images, Options{
+		BeforeRemoveImage: func(i Image) error { return fmt.Errorf("unable to archive %s", i.ID) },
+	})
+	require.Equal(t, "unable to archive sha256:i1", results[0].Error)
+	require.Empty(t, docker.calls)
+
+	var moved []string
+	containers, err := SelectContainers(context.Background(), docker, "")
+	require.Nil(t, err, "Expected no Error")
+	results = DeleteContainers(context.Background(), docker, containers, Options{
+		Skip:            func(kind, id string) bool { return id == "c1" },
+		RemoveContainer: func(c Container) error { moved = append(moved, c.ID); return nil },
+	})
+	require.Len(t, results, 1)
+	require.Equal(t, []string{"c2"}, moved)
+	require.Empty(t, docker.calls)
+
+	var summary Summary
+	summary.Add(results)
+	summary.Add([]Result{{Kind: "image", ID: "sha256:i1", Error: "conflict"}})
+	require.Equal(t, 1, summary.Containers)
+	require.Equal(t, 1, summary.Failed)
+	require.Len(t, summary.Results, 2)
+}
+
+// the docker client has to satisfy the interface
+var _ Docker = client.CommonAPIClient(nil)
+
+func TestUniformFields(t *testing.T) {
+	now := time.Unix(1500000100, 0)
+	containers := []types.Container{
+		{ID: "c1", Names: []string{"/firefox"}, State: "running", ImageID: "sha256:i1", Created: 1500000000, SizeRw: 42,
+			NetworkSettings: &types.SummaryNetworkSettings{Networks: map[string]*network.EndpointSettings{"ci": {NetworkID: "n1"}}}},
+	}
+
+	c := NewContainer(containers[0], now)
+	require.Equal(t, Common{Kind: "container", CreatedAt: "2017-07-14T02:40:00Z", AgeSeconds: 100, SizeBytes: 42, InUse: true}, c.Common)
+	require.Equal(t, "firefox", c.Name)
+
+	i := NewImage(types.ImageSummary{ID: "sha256:i1", RepoTags: []string{"<none>:<none>", "firefox:latest"}, Size: 7}, containers, now)
+	require.Equal(t, "firefox:latest", i.Name)
+	require.True(t, i.InUse)
+	require.Equal(t, int64(7), i.SizeBytes)
+	i = NewImage(types.ImageSummary{ID: "sha256:i2", RepoTags: []string{"<none>:<none>"}}, containers, now)
+	require.Equal(t, "", i.Name)
+	require.False(t, i.InUse)
+
+	n := NewNetwork(types.NetworkResource{ID: "n1", Name: "ci", Created: time.Unix(1500000000, 0)}, containers, now)
+	require.Equal(t, "network", n.Kind)
+	require.True(t, n.InUse)
+	require.Equal(t, int64(1500000000), n.Created)
+
+	// one filter works for all kinds
+	for _, doc := range []interface{}{c, n} {
+		ok, err := Matches(doc, `.InUse and .AgeSeconds >= 100 and .CreatedAt == "2017-07-14T02:40:00Z"`)
+		require.Nil(t, err, "Expected no Error")
+		require.True(t, ok)
+	}
+}
@@ -0,0 +1,283 @@
+package purge
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Schema is a JSON Schema of an entity document
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 interface{}        `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Kinds are the kinds of entities, in the order they are purged
+var Kinds = []string{"container", "image", "network"}
+
+var kindTypes = map[string]reflect.Type{
+	"container": reflect.TypeOf(Container{}),
+	"image":     reflect.TypeOf(Image{}),
+	"network":   reflect.TypeOf(Network{}),
+}
+
+// fieldDescriptions documents the top level fields that are easy to get wrong
+var fieldDescriptions = map[string]string{
+	"IsContainer": "true for containers",
+	"IsImage":     "true for images",
+	"IsNetwork":   "true for networks",
+	"Created":     "unix time the entity was created",
+	"LastUsed":    "unix time the image was last used by a container, 0 if unknown",
+	"Kind":        "container, image or network",
+	"Name":        "name of the container without the leading slash, first tag of the image or name of the network",
+	"CreatedAt":   "Created in RFC3339",
+	"AgeSeconds":  "seconds since the entity was created",
+	"SizeBytes":   "size of the writable layer of a container (only calculated if the filter references a size), size of an image, 0 for networks",
+	"InUse":       "containers are running, images and networks are used by a container",
+	"Labels":      "labels of the entity",
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawMessageType    = reflect.TypeOf(json.RawMessage{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// KindSchema returns the JSON Schema of the documents of a kind, the filters of that kind operate on
+func KindSchema(kind string) (*Schema, error) {
+	t, ok := kindTypes[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown kind `%s'", kind)
+	}
+	s := typeSchema(t, make(map[reflect.Type]bool))
+	s.Schema = "http://json-schema.org/draft-07/schema#"
+	s.Title = kind
+	for name, description := range fieldDescriptions {
+		if p, ok := s.Properties[name]; ok {
+			p.Description = description
+		}
+	}
+	return s, nil
+}
+
+// typeSchema returns the schema of the json encoding of the type,
+// seen holds the struct types that are being described to stop on recursive types
+func typeSchema(t reflect.Type, seen map[reflect.Type]bool) *Schema {
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	if t == rawMessageType {
+		return &Schema{}
+	}
+	if t.Kind() != reflect.Ptr && (t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType)) {
+		return &Schema{Type: "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Ptr:
+		s := typeSchema(t.Elem(), seen)
+		return nullable(s)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json encodes byte slices as base64 strings
+			return &Schema{Type: []string{"string", "null"}}
+		}
+		return &Schema{Type: []string{"array", "null"}, Items: typeSchema(t.Elem(), seen)}
+	case reflect.Array:
+		return &Schema{Type: "array", Items: typeSchema(t.Elem(), seen)}
+	case reflect.Map:
+		return &Schema{Type: []string{"object", "null"}, AdditionalProperties: typeSchema(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return &Schema{Type: "object"}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		for _, f := range jsonFields(t) {
+			s.Properties[f.name] = typeSchema(f.typ, seen)
+		}
+		return s
+	}
+	// interfaces can hold anything
+	return &Schema{}
+}
+
+func nullable(s *Schema) *Schema {
+	if typ, ok := s.Type.(string); ok {
+		s.Type = []string{typ, "null"}
+	}
+	return s
+}
+
+type jsonField struct {
+	name   string
+	typ    reflect.Type
+	depth  int
+	tagged bool
+}
+
+// jsonFields returns the fields encoding/json encodes for the struct type,
+// fields of embedded structs are promoted and shadowed by less nested fields with the same name
+func jsonFields(t reflect.Type) []jsonField {
+	var candidates []jsonField
+	var walk func(t reflect.Type, depth int)
+	walk = func(t reflect.Type, depth int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name := strings.Split(tag, ",")[0]
+			ft := f.Type
+			if f.Anonymous && name == "" {
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct {
+					walk(ft, depth+1)
+					continue
+				}
+			}
+			if f.PkgPath != "" {
+				continue
+			}
+			tagged := name != ""
+			if !tagged {
+				name = f.Name
+			}
+			candidates = append(candidates, jsonField{name: name, typ: f.Type, depth: depth, tagged: tagged})
+		}
+	}
+	walk(t, 0)
+
+	byName := make(map[string][]jsonField)
+	var names []string
+	for _, f := range candidates {
+		if _, ok := byName[f.name]; !ok {
+			names = append(names, f.name)
+		}
+		byName[f.name] = append(byName[f.name], f)
+	}
+	sort.Strings(names)
+
+	var fields []jsonField
+	for _, name := range names {
+		if f, ok := dominantField(byName[name]); ok {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// dominantField applies the rules of encoding/json to fields with the same name:
+// the least nested field wins, tagged fields win over untagged ones, otherwise the name is dropped
+func dominantField(fields []jsonField) (jsonField, bool) {
+	minDepth := fields[0].depth
+	for _, f := range fields {
+		if f.depth < minDepth {
+			minDepth = f.depth
+		}
+	}
+	var shallow, tagged []jsonField
+	for _, f := range fields {
+		if f.depth == minDepth {
+			shallow = append(shallow, f)
+			if f.tagged {
+				tagged = append(tagged, f)
+			}
+		}
+	}
+	if len(shallow) == 1 {
+		return shallow[0], true
+	}
+	if len(tagged) == 1 {
+		return tagged[0], true
+	}
+	return jsonField{}, false
+}
+
+var (
+	stringLiteral = regexp.MustCompile(`"(\\.|[^"\\])*"`)
+	fieldPath     = regexp.MustCompile(`(\.[A-Za-z_][A-Za-z0-9_]*\??(\[[^\]]*\])*)+`)
+	fieldName     = regexp.MustCompile(`\.([A-Za-z_][A-Za-z0-9_]*)`)
+)
+
+// knownFields holds the field names of all kinds at any depth,
+// opaqueFields the names of fields with arbitrary keys like Labels
+var knownFields, opaqueFields = collectFieldNames()
+
+func collectFieldNames() (known, opaque map[string]bool) {
+	known = make(map[string]bool)
+	opaque = make(map[string]bool)
+	var walk func(s *Schema)
+	walk = func(s *Schema) {
+		for name, p := range s.Properties {
+			known[name] = true
+			if p.AdditionalProperties != nil || p.Type == nil {
+				opaque[name] = true
+			}
+			walk(p)
+		}
+		if s.Items != nil {
+			walk(s.Items)
+		}
+		if s.AdditionalProperties != nil {
+			walk(s.AdditionalProperties)
+		}
+	}
+	for _, kind := range Kinds {
+		s, _ := KindSchema(kind)
+		walk(s)
+	}
+	return known, opaque
+}
+
+// UnknownFields returns the fields the filter references that no entity document has.
+// It is a heuristic: the documents are not tracked through pipes,
+// so a field is known if any document has it at any depth.
+func UnknownFields(filter string) []string {
+	filter = stringLiteral.ReplaceAllString(filter, `""`)
+	var unknown []string
+	reported := make(map[string]bool)
+	for _, loc := range fieldPath.FindAllStringIndex(filter, -1) {
+		if loc[0] > 0 && strings.ContainsAny(filter[loc[0]-1:loc[0]], `abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_$)]"`) {
+			// a field of a variable, a function result or a number
+			continue
+		}
+		for _, m := range fieldName.FindAllStringSubmatch(filter[loc[0]:loc[1]], -1) {
+			name := m[1]
+			if !knownFields[name] {
+				if !reported[name] {
+					reported[name] = true
+					unknown = append(unknown, name)
+				}
+				break
+			}
+			if opaqueFields[name] {
+				break
+			}
+		}
+	}
+	return unknown
+}
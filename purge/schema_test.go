@@ -0,0 +1,42 @@
+package purge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKindSchema(t *testing.T) {
+	s, err := KindSchema("network")
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, "network", s.Title)
+	// the Created of the wrapper shadows the time of the NetworkResource
+	require.Equal(t, "integer", s.Properties["Created"].Type)
+	require.Equal(t, "unix time the entity was created", s.Properties["Created"].Description)
+	require.Equal(t, "boolean", s.Properties["IsNetwork"].Type)
+	require.Equal(t, []string{"object", "null"}, s.Properties["Labels"].Type)
+	require.Equal(t, "string", s.Properties["Labels"].AdditionalProperties.Type)
+
+	s, err = KindSchema("container")
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, []string{"array", "null"}, s.Properties["Names"].Type)
+	require.Equal(t, "string", s.Properties["Names"].Items.Type)
+	require.Equal(t, "integer", s.Properties["Ports"].Items.Properties["PrivatePort"].Type)
+	require.Nil(t, s.Properties["LastUsed"])
+
+	s, err = KindSchema("image")
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, "integer", s.Properties["LastUsed"].Type)
+
+	_, err = KindSchema("volume")
+	require.NotNil(t, err)
+}
+
+func TestUnknownFields(t *testing.T) {
+	require.Empty(t, UnknownFields(`.IsContainer == true and (.Image | contains("firefox.Foo"))`))
+	require.Empty(t, UnknownFields(`.Labels.team == "web" and .Labels["docker-purge.keep"] == null`))
+	require.Empty(t, UnknownFields(`.Ports[] | .PrivatePort == 80`))
+	require.Empty(t, UnknownFields(`.Created < (now - 3600) and 1.5 > 1 and $__loc__.file == null`))
+	require.Equal(t, []string{"Stat", "Lastused"}, UnknownFields(`.Stat == "exited" or .Lastused < 0 or .Stat == "dead"`))
+	require.Equal(t, []string{"Foo"}, UnknownFields(`.NetworkSettings.Foo.Bar`))
+}
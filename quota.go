@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+	units "github.com/docker/go-units"
+)
+
+// quota limits the entities an owner may have per kind, 0 means unlimited
+type quota struct {
+	Count int
+	Size  int64
+}
+
+// quotaEntity is a container, image or network that belongs to an owner
+type quotaEntity struct {
+	kind    string
+	id      string
+	created int64
+	size    int64
+	// inUse entities are counted but never removed, like running containers and the images they use
+	inUse bool
+	purge func() bool
+}
+
+// parseQuota parses a quota in the format OWNER:count=N,size=SIZE,
+// OWNER can be * to specify the quota for owners without an explicit quota
+func parseQuota(s string) (string, quota, error) {
+	var q quota
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", q, fmt.Errorf("invalid quota `%s', expected OWNER:count=N,size=SIZE", s)
+	}
+	for _, limit := range strings.Split(parts[1], ",") {
+		kv := strings.SplitN(limit, "=", 2)
+		if len(kv) != 2 {
+			return "", q, fmt.Errorf("invalid quota limit `%s'", limit)
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "count":
+			n, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+			if err != nil || n < 0 {
+				return "", q, fmt.Errorf("invalid quota count `%s'", kv[1])
+			}
+			q.Count = n
+		case "size":
+			n, err := units.FromHumanSize(strings.TrimSpace(kv[1]))
+			if err != nil {
+				return "", q, fmt.Errorf("invalid quota size `%s': %s", kv[1], err.Error())
+			}
+			q.Size = n
+		default:
+			return "", q, fmt.Errorf("unknown quota limit `%s'", kv[0])
+		}
+	}
+	return parts[0], q, nil
+}
+
+func handleQuota(dockerClient *client.Client, origin purgeOrigin) {
+	quotas := make(map[string]quota)
+	for _, s := range *quotaFlag {
+		owner, q, err := parseQuota(s)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		quotas[owner] = q
+	}
+
+	if *dryRunFlag {
+		fmt.Fprintln(os.Stdout, "Dry mode on")
+	}
+
+	if !*limitToContainerFlag && !*limitToImageFlag && !*limitToNetworkFlag {
+		*limitToContainerFlag = true
+		*limitToImageFlag = true
+		*limitToNetworkFlag = true
+	}
+
+	if err := purgeToQuota(dockerClient, *quotaLabelFlag, quotas, *filterArg, origin); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+// purgeToQuota groups the entities matching the filter by the value of the owner label
+// and removes the oldest entities of every owner that exceeds its quota,
+// entities in use are never removed
+func purgeToQuota(dockerClient *client.Client, ownerLabel string, quotas map[string]quota, filter string, origin purgeOrigin) error {
+	origin.Rule = "quota"
+	origin.Filter = filter
+	origin.Dry = *dryRunFlag
+	origin.started = time.Now()
+	sizes, err := getDiskUsage(dockerClient)
+	if err != nil {
+		return err
+	}
+
+	// owners maps kind => owner => entities
+	owners := make(map[string]map[string][]*quotaEntity)
+	add := func(owner string, e *quotaEntity) {
+		if owners[e.kind] == nil {
+			owners[e.kind] = make(map[string][]*quotaEntity)
+		}
+		owners[e.kind][owner] = append(owners[e.kind][owner], e)
+	}
+
+	if *limitToContainerFlag {
+		containers, err := selectContainers(dockerClient, filter)
+		if err != nil {
+			return err
+		}
+		for i := range containers {
+			c := &containers[i]
+			if owner, ok := c.Labels[ownerLabel]; ok {
+				add(owner, &quotaEntity{kind: "container", id: c.ID, created: c.Created, size: sizes.containers[c.ID], inUse: c.InUse, purge: func() bool {
+					return purgeContainer(dockerClient, c, origin)
+				}})
+			}
+		}
+	}
+
+	if *limitToImageFlag {
+		images, err := selectImages(dockerClient, origin.state, filter)
+		if err != nil {
+			return err
+		}
+		for i := range images {
+			img := &images[i]
+			if owner, ok := img.Labels[ownerLabel]; ok {
+				add(owner, &quotaEntity{kind: "image", id: img.ID, created: img.Created, size: sizes.images[img.ID], inUse: img.InUse, purge: func() bool {
+					return purgeImage(dockerClient, img, origin)
+				}})
+			}
+		}
+	}
+
+	if *limitToNetworkFlag {
+		networks, err := selectNetworks(dockerClient, filter)
+		if err != nil {
+			return err
+		}
+		for i := range networks {
+			n := &networks[i]
+			if owner, ok := n.Labels[ownerLabel]; ok {
+				add(owner, &quotaEntity{kind: "network", id: n.ID, created: n.Created, inUse: n.InUse, purge: func() bool {
+					return purgeNetwork(dockerClient, n, origin)
+				}})
+			}
+		}
+	}
+
+	for _, kind := range []string{"container", "image", "network"} {
+		var names []string
+		for owner := range owners[kind] {
+			names = append(names, owner)
+		}
+		sort.Strings(names)
+
+		for _, owner := range names {
+			q, ok := quotas[owner]
+			if !ok {
+				q = quotas["*"]
+			}
+			entities := owners[kind][owner]
+			sort.SliceStable(entities, func(i, j int) bool {
+				return entities[i].created < entities[j].created
+			})
+
+			count := len(entities)
+			var size int64
+			for _, e := range entities {
+				size += e.size
+			}
+
+			if *dryRunFlag {
+				fmt.Fprintf(os.Stdout, "%s %s=%s: %d of %s %ss, %s of %s\n",
+					kind, ownerLabel, owner,
+					count, quotaCountString(q.Count), kind,
+					units.HumanSize(float64(size)), quotaSizeString(q.Size))
+			}
+
+			for _, e := range entities {
+				if !(q.Count > 0 && count > q.Count) && !(q.Size > 0 && size > q.Size) {
+					break
+				}
+				if e.inUse {
+					continue
+				}
+				if e.purge() {
+					count--
+					size -= e.size
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func quotaCountString(n int) string {
+	if n <= 0 {
+		return "unlimited"
+	}
+	return strconv.Itoa(n)
+}
+
+func quotaSizeString(n int64) string {
+	if n <= 0 {
+		return "unlimited"
+	}
+	return units.HumanSize(float64(n))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQuota(t *testing.T) {
+	tests := []struct {
+		Input    string
+		Owner    string
+		Quota    quota
+		HasError bool
+	}{
+		{"team-a:count=20", "team-a", quota{Count: 20}, false},
+		{"*:count=20,size=10GB", "*", quota{Count: 20, Size: 10000000000}, false},
+		{"team-a: size = 1KB ", "team-a", quota{Size: 1000}, false},
+		{"team-a:count=0", "team-a", quota{}, false},
+		{"team-a", "", quota{}, true},
+		{":count=1", "", quota{}, true},
+		{"team-a:count=-1", "", quota{}, true},
+		{"team-a:count", "", quota{}, true},
+		{"team-a:size=lots", "", quota{}, true},
+		{"team-a:age=1d", "", quota{}, true},
+	}
+	for _, test := range tests {
+		owner, q, err := parseQuota(test.Input)
+		if test.HasError {
+			require.NotNil(t, err, test.Input)
+			continue
+		}
+		require.Nil(t, err, test.Input)
+		require.Equal(t, test.Owner, owner)
+		require.Equal(t, test.Quota, q)
+	}
+}
+
+func TestPurgeToQuota(t *testing.T) {
+	defer func(containers bool) { *limitToContainerFlag = containers }(*limitToContainerFlag)
+	*limitToContainerFlag = true
+
+	docker := newFakeDocker(t)
+	defer docker.close()
+	team := func(name string) map[string]string { return map[string]string{"team": name} }
+	docker.containers = []types.Container{
+		{ID: "a3", Labels: team("a"), Created: 300, State: "exited"},
+		{ID: "a1", Labels: team("a"), Created: 100, State: "exited"},
+		{ID: "a2", Labels: team("a"), Created: 200, State: "running"},
+		{ID: "b1", Labels: team("b"), Created: 100, State: "exited"},
+		{ID: "b2", Labels: team("b"), Created: 200, State: "exited"},
+		{ID: "c1", Labels: team("c"), Created: 100, State: "exited", SizeRw: 20},
+		{ID: "c2", Labels: team("c"), Created: 200, State: "exited", SizeRw: 20},
+		{ID: "x1", Created: 100, State: "exited"},
+	}
+	quotas := map[string]quota{"*": {Count: 1}, "b": {Count: 2}, "c": {Size: 30}}
+
+	// a is over the default quota, its oldest stopped containers are removed but the running one is kept,
+	// b is within its quota, c is over its size and entities without owner are ignored
+	require.Nil(t, purgeToQuota(docker.client(t), "team", quotas, "", purgeOrigin{}))
+	require.Equal(t, []string{"DELETE /containers/a1", "DELETE /containers/a3", "DELETE /containers/c1"}, docker.mutations())
+}
+
+func TestQuotaRejectsBudget(t *testing.T) {
+	docker := newE2EDocker(t)
+	defer docker.close()
+
+	result := runCLI(t, docker, "--budget", "1GB", "--quota", "*:count=1")
+	require.Equal(t, 1, result.code)
+	require.Contains(t, result.stderr, "--budget and --quota cannot be combined")
+	require.Empty(t, docker.mutations())
+}
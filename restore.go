@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/docker/docker/client"
+)
+
+// handleRestore restores the entities from the trash, or images from the archive
+// if they are not in the trash
+func handleRestore(dockerClient *client.Client, state *stateStore, archive *imageArchive) {
+	failed := false
+	for _, id := range *restoreIDArg {
+		if err := restore(dockerClient, state, archive, id); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func restore(dockerClient *client.Client, state *stateStore, archive *imageArchive, id string) error {
+	var err error
+	if state != nil {
+		var e *trashEntry
+		if e, err = state.findTrash(id); err == nil {
+			if *dryRunFlag {
+				fmt.Fprintf(os.Stdout, "Would restore %s %s\n", e.Kind, e.ID)
+				return nil
+			}
+			if err := restoreTrash(dockerClient, state, e); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stdout, "Restored %s %s\n", e.Kind, e.ID)
+			return nil
+		}
+	}
+	if archive != nil {
+		var e *archiveEntry
+		if e, err = archive.find(id); err == nil {
+			if *dryRunFlag {
+				fmt.Fprintf(os.Stdout, "Would load image %s from %s\n", e.ID, e.File)
+				return nil
+			}
+			if err := archive.load(dockerClient, e); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stdout, "Loaded image %s from %s\n", e.ID, e.File)
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	networktypes "github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+)
+
+// resurrectRepository is the repository of images created from committed or exported containers
+const resurrectRepository = "docker-purge-resurrect"
+
+// containerRecord holds everything needed to recreate a removed container
+type containerRecord struct {
+	Container types.ContainerJSON `json:"container"`
+	// Image is the image the container was committed to, if any
+	Image string `json:"image,omitempty"`
+	// Export is the file the filesystem of the container was exported to, if any
+	Export string `json:"export,omitempty"`
+	// Recorded is the unix time the record was created
+	Recorded int64 `json:"recorded"`
+}
+
+// containerRecorder saves the configuration of containers before they are removed
+type containerRecorder struct {
+	dir    string
+	export bool
+	commit bool
+}
+
+func openContainerRecorder(dir string, export, commit bool) (*containerRecorder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &containerRecorder{dir: dir, export: export, commit: commit}, nil
+}
+
+func resurrectImageRef(id string) string {
+	return resurrectRepository + "/" + shortID(id) + ":latest"
+}
+
+// save records the container, the container must not be removed if this fails.
+// Trashed containers are recorded with the name and state they had before they were trashed.
+func (r *containerRecorder) save(dockerClient *client.Client, id string, trashed *trashEntry) error {
+	if r == nil {
+		return nil
+	}
+	details, err := dockerClient.ContainerInspect(context.Background(), id)
+	if err != nil {
+		return fmt.Errorf("unable to inspect container %s: %s", id, err.Error())
+	}
+	if trashed != nil && details.ContainerJSONBase != nil {
+		details.Name = "/" + trashed.Name
+		if details.State != nil {
+			containerState := *details.State
+			containerState.Running = trashed.Running
+			details.State = &containerState
+		}
+	}
+	record := containerRecord{Container: details, Recorded: time.Now().Unix()}
+
+	if r.commit {
+		if _, err := dockerClient.ContainerCommit(context.Background(), id, types.ContainerCommitOptions{
+			Reference: resurrectImageRef(id),
+			Comment:   "committed by docker-purge before removal",
+		}); err != nil {
+			return fmt.Errorf("unable to commit container %s: %s", id, err.Error())
+		}
+		record.Image = resurrectImageRef(id)
+	}
+
+	if r.export {
+		record.Export = id + ".tar.gz"
+		if err := r.exportContainer(dockerClient, id, filepath.Join(r.dir, record.Export)); err != nil {
+			return fmt.Errorf("unable to export container %s: %s", id, err.Error())
+		}
+	}
+
+	buf, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(r.dir, id+".json"), buf, 0600); err != nil {
+		return fmt.Errorf("unable to record container %s: %s", id, err.Error())
+	}
+	return nil
+}
+
+func (r *containerRecorder) exportContainer(dockerClient *client.Client, id, path string) error {
+	rc, err := dockerClient.ContainerExport(context.Background(), id)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	tmp, err := ioutil.TempFile(r.dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(tmp)
+	_, err = io.Copy(gz, rc)
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// find returns the newest record of the container with the id, id prefix or name
+func (r *containerRecorder) find(ref string) (*containerRecord, error) {
+	files, err := ioutil.ReadDir(r.dir)
+	if err != nil {
+		return nil, err
+	}
+	var found *containerRecord
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		buf, err := ioutil.ReadFile(filepath.Join(r.dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var record containerRecord
+		if err := json.Unmarshal(buf, &record); err != nil || record.Container.ContainerJSONBase == nil {
+			fmt.Fprintf(os.Stderr, "ignoring invalid container record %s\n", fi.Name())
+			continue
+		}
+		if !strings.HasPrefix(record.Container.ID, ref) && strings.TrimPrefix(record.Container.Name, "/") != strings.TrimPrefix(ref, "/") {
+			continue
+		}
+		if found != nil && found.Container.ID != record.Container.ID {
+			if strings.HasPrefix(record.Container.ID, ref) {
+				return nil, fmt.Errorf("`%s' matches more than one recorded container", ref)
+			}
+			// the same name was used by several containers, take the newest
+			if record.Recorded < found.Recorded {
+				continue
+			}
+		}
+		found = &record
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no recorded container `%s'", ref)
+	}
+	return found, nil
+}
+
+// resurrect recreates the container from the record and starts it if it was running
+func (r *containerRecorder) resurrect(dockerClient *client.Client, record *containerRecord) (string, error) {
+	c := record.Container
+	if c.Config == nil || c.HostConfig == nil {
+		return "", fmt.Errorf("record of container %s is incomplete", c.ID)
+	}
+	config := *c.Config
+
+	// prefer the committed image, then the export, then the original image
+	switch {
+	case record.Image != "":
+		config.Image = record.Image
+	case record.Export != "":
+		if err := r.importExport(dockerClient, record); err != nil {
+			return "", err
+		}
+		config.Image = resurrectImageRef(c.ID)
+	default:
+		if _, _, err := dockerClient.ImageInspectWithRaw(context.Background(), c.Image); err == nil {
+			config.Image = c.Image
+		}
+	}
+
+	// only one network can be specified on create, the others are connected afterwards
+	var networkingConfig networktypes.NetworkingConfig
+	var others map[string]*networktypes.EndpointSettings
+	if c.NetworkSettings != nil {
+		others = make(map[string]*networktypes.EndpointSettings)
+		for name, settings := range c.NetworkSettings.Networks {
+			endpoint := &networktypes.EndpointSettings{}
+			if settings != nil {
+				endpoint.IPAMConfig = settings.IPAMConfig
+				endpoint.Links = settings.Links
+				endpoint.Aliases = settings.Aliases
+			}
+			if name == string(c.HostConfig.NetworkMode) || (c.HostConfig.NetworkMode.IsDefault() && name == "bridge") {
+				networkingConfig.EndpointsConfig = map[string]*networktypes.EndpointSettings{name: endpoint}
+			} else {
+				others[name] = endpoint
+			}
+		}
+	}
+
+	created, err := dockerClient.ContainerCreate(context.Background(), &config, c.HostConfig, &networkingConfig, strings.TrimPrefix(c.Name, "/"))
+	if err != nil {
+		return "", fmt.Errorf("unable to create container %s: %s", strings.TrimPrefix(c.Name, "/"), err.Error())
+	}
+	for name, endpoint := range others {
+		if err := dockerClient.NetworkConnect(context.Background(), name, created.ID, endpoint); err != nil {
+			return created.ID, fmt.Errorf("unable to connect container %s to network %s: %s", created.ID, name, err.Error())
+		}
+	}
+	if c.State != nil && c.State.Running {
+		if err := dockerClient.ContainerStart(context.Background(), created.ID, types.ContainerStartOptions{}); err != nil {
+			return created.ID, fmt.Errorf("unable to start container %s: %s", created.ID, err.Error())
+		}
+	}
+	return created.ID, nil
+}
+
+// importExport imports the exported filesystem of the container as image
+func (r *containerRecorder) importExport(dockerClient *client.Client, record *containerRecord) error {
+	f, err := os.Open(filepath.Join(r.dir, record.Export))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("unable to read export %s: %s", record.Export, err.Error())
+	}
+	rc, err := dockerClient.ImageImport(context.Background(), types.ImageImportSource{Source: gz, SourceName: "-"}, resurrectImageRef(record.Container.ID), types.ImageImportOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to import export %s: %s", record.Export, err.Error())
+	}
+	defer rc.Close()
+	_, err = io.Copy(ioutil.Discard, rc)
+	return err
+}
+
+func handleResurrect(dockerClient *client.Client, recorder *containerRecorder) {
+	record, err := recorder.find(*resurrectIDArg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if *dryRunFlag {
+		fmt.Fprintf(os.Stdout, "Would resurrect container %s as %s\n", record.Container.ID, strings.TrimPrefix(record.Container.Name, "/"))
+		return
+	}
+	id, err := recorder.resurrect(dockerClient, record)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stdout, "Resurrected container %s as %s\n", record.Container.ID, id)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	networktypes "github.com/docker/docker/api/types/network"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResurrect(t *testing.T) {
+	docker := newFakeDocker(t)
+	defer docker.close()
+	docker.containers = []types.Container{{ID: "c1", Names: []string{"/firefox"}, Image: "firefox", ImageID: "sha256:i1", State: "running"}}
+	docker.details["c1"] = types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:         "c1",
+			Name:       "/firefox",
+			Image:      "sha256:i1",
+			State:      &types.ContainerState{Running: true},
+			HostConfig: &containertypes.HostConfig{NetworkMode: "default", Binds: []string{"/data:/data"}},
+		},
+		Config: &containertypes.Config{Image: "firefox", Cmd: []string{"firefox", "--private"}},
+		NetworkSettings: &types.NetworkSettings{Networks: map[string]*networktypes.EndpointSettings{
+			"bridge":   {IPAddress: "172.17.0.2"},
+			"frontend": {Aliases: []string{"browser"}},
+		}},
+	}
+	dockerClient := docker.client(t)
+
+	dir, removeDir := newTempDir(t)
+	defer removeDir()
+	r, err := openContainerRecorder(dir, false, true)
+	require.Nil(t, err, "Expected no Error")
+	require.Nil(t, r.save(dockerClient, "c1", nil))
+
+	_, err = r.find("chrome")
+	require.NotNil(t, err)
+	record, err := r.find("firefox")
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, resurrectImageRef("c1"), record.Image)
+
+	id, err := r.resurrect(dockerClient, record)
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, "created-1", id)
+	require.Equal(t, []string{
+		"POST /containers/create?name=firefox",
+		"POST /networks/frontend/connect",
+		"POST /containers/created-1/start",
+	}, docker.mutations()[1:])
+	require.Len(t, docker.created, 1)
+	created := docker.created[0]
+	require.Equal(t, resurrectImageRef("c1"), created.Image)
+	require.Equal(t, []string{"firefox", "--private"}, []string(created.Cmd))
+	require.Equal(t, []string{"/data:/data"}, created.HostConfig.Binds)
+	require.Empty(t, created.NetworkingConfig.EndpointsConfig["bridge"].IPAddress)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduleNext(t *testing.T) {
+	// Monday
+	now := time.Date(2019, 3, 11, 10, 17, 30, 0, time.UTC)
+	tests := []struct {
+		Schedule string
+		Next     time.Time
+	}{
+		{
+			"* * * * *",
+			time.Date(2019, 3, 11, 10, 18, 0, 0, time.UTC),
+		},
+		{
+			"*/15 * * * *",
+			time.Date(2019, 3, 11, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			"0 3 * * *",
+			time.Date(2019, 3, 12, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			"@hourly",
+			time.Date(2019, 3, 11, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			"30 8 * * 6,7",
+			time.Date(2019, 3, 16, 8, 30, 0, 0, time.UTC),
+		},
+		{
+			"0 0 1 1-6/2 *",
+			time.Date(2019, 5, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			// either the 20th or a friday
+			"0 0 20 * 5",
+			time.Date(2019, 3, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			"@every 90s",
+			time.Date(2019, 3, 11, 10, 19, 0, 0, time.UTC),
+		},
+	}
+	for _, test := range tests {
+		s, err := parseSchedule(test.Schedule)
+		require.Nil(t, err, "Expected no Error for %s", test.Schedule)
+		require.Equal(t, test.Next, s.next(now), test.Schedule)
+	}
+}
+
+func TestScheduleInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"@every 1ms",
+		"@sometimes",
+	} {
+		_, err := parseSchedule(expr)
+		require.NotNil(t, err, "Expected Error for `%s'", expr)
+	}
+}
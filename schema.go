@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/Eun/docker-purge/purge"
+)
+
+func handleSchema() {
+	var v interface{}
+	if *schemaKindArg != "" {
+		s, err := purge.KindSchema(*schemaKindArg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		v = s
+	} else {
+		schemas := make(map[string]*purge.Schema)
+		for _, kind := range purge.Kinds {
+			s, err := purge.KindSchema(kind)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				os.Exit(1)
+			}
+			schemas[kind] = s
+		}
+		v = schemas
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// warnUnknownFields warns about fields the filter references that no entity has, they are most likely typos
+func warnUnknownFields(prefix, filter string) {
+	for _, field := range purge.UnknownFields(filter) {
+		fmt.Fprintf(os.Stderr, "warning: %sunknown field .%s in filter `%s', see docker-purge schema\n", prefix, field, filter)
+	}
+}
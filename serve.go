@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Eun/docker-purge/jq"
+	"github.com/docker/docker/client"
+)
+
+// serveMaxRuns is the number of past runs the server keeps
+const serveMaxRuns = 100
+
+// run is a purge or dry run that was triggered through the api
+type run struct {
+	ID       int           `json:"id"`
+	Policy   policy        `json:"policy"`
+	Status   string        `json:"status"`
+	Started  time.Time     `json:"started"`
+	Finished *time.Time    `json:"finished,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	Summary  *purgeSummary `json:"summary,omitempty"`
+	done     chan struct{}
+}
+
+const (
+	runStatusRunning = "running"
+	runStatusDone    = "done"
+	runStatusFailed  = "failed"
+)
+
+// server exposes docker-purge as http api
+type server struct {
+	dockerClient *client.Client
+	token        string
+	// origin holds the stores the runs purge with
+	origin purgeOrigin
+
+	mu     sync.Mutex
+	runs   []*run
+	nextID int
+	// purgeMu makes sure only one run deletes entities at a time
+	purgeMu sync.Mutex
+}
+
+func newServer(dockerClient *client.Client, token string, origin purgeOrigin) *server {
+	origin.Rule = "api"
+	return &server{
+		dockerClient: dockerClient,
+		token:        token,
+		origin:       origin,
+		nextID:       1,
+	}
+}
+
+func handleServe(dockerClient *client.Client, origin purgeOrigin) {
+	if *serveTokenFlag == "" && !isLoopbackAddr(*serveListenFlag) {
+		fmt.Fprintf(os.Stderr, "refusing to listen on %s without --token (or DOCKER_PURGE_TOKEN), everyone who can reach the api could purge\n", *serveListenFlag)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stdout, "listening on %s\n", *serveListenFlag)
+	if err := http.ListenAndServe(*serveListenFlag, newServer(dockerClient, *serveTokenFlag, origin).handler()); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+// isLoopbackAddr reports whether the listen address is only reachable from this host
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/entities", s.handleEntities)
+	mux.HandleFunc("/dry-run", s.handleTrigger(true))
+	mux.HandleFunc("/purge", s.handleTrigger(false))
+	mux.HandleFunc("/runs", s.handleRuns)
+	mux.HandleFunc("/runs/", s.handleRun)
+	mux.HandleFunc("/metrics", s.origin.metrics.handler(s.dockerClient, *metricsLabelFlag))
+	return s.authenticate(mux)
+}
+
+func (s *server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			auth := r.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, "Bearer ") ||
+				subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(s.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, "invalid or missing token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleEntities lists the entities that match the filter
+// GET /entities?filter=...&kind=containers,images,networks
+func (s *server) handleEntities(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	filter := r.URL.Query().Get("filter")
+	if !jq.IsValidFilter(filter) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid filter `%s'", filter))
+		return
+	}
+
+	kinds := r.URL.Query().Get("kind")
+	all := kinds == ""
+	entities := make(map[string]interface{})
+	for _, kind := range strings.Split(kinds, ",") {
+		switch kind {
+		case "", "containers", "images", "networks":
+		default:
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown kind `%s'", kind))
+			return
+		}
+	}
+
+	if all || strings.Contains(kinds, "containers") {
+		containers, err := selectContainers(s.dockerClient, filter)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		entities["containers"] = nonNil(containers)
+	}
+	if all || strings.Contains(kinds, "images") {
+		images, err := selectImages(s.dockerClient, s.origin.state, filter)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		entities["images"] = nonNil(images)
+	}
+	if all || strings.Contains(kinds, "networks") {
+		networks, err := selectNetworks(s.dockerClient, filter)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		entities["networks"] = nonNil(networks)
+	}
+	writeJSON(w, http.StatusOK, entities)
+}
+
+// handleTrigger starts a run of the policy in the request body
+// POST /purge and POST /dry-run, add ?wait=true to wait for the run to finish
+func (s *server) handleTrigger(dry bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		var p policy
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid policy: %s", err.Error()))
+				return
+			}
+		}
+		if !dry && strings.TrimSpace(p.Filter) == "" {
+			// an empty filter matches everything
+			writeError(w, http.StatusBadRequest, "missing filter")
+			return
+		}
+		if !jq.IsValidFilter(p.Filter) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid filter `%s'", p.Filter))
+			return
+		}
+		if p.Trash && s.origin.state == nil {
+			writeError(w, http.StatusBadRequest, "the trash requires --state-file")
+			return
+		}
+		p.Dry = dry
+
+		rn := s.startRun(p, "api "+r.RemoteAddr)
+		if wait, _ := strconv.ParseBool(r.URL.Query().Get("wait")); wait {
+			select {
+			case <-rn.done:
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		w.Header().Set("Location", fmt.Sprintf("/runs/%d", rn.ID))
+		if rn.Status == runStatusRunning {
+			writeJSON(w, http.StatusAccepted, rn)
+		} else {
+			writeJSON(w, http.StatusOK, rn)
+		}
+	}
+}
+
+// handleRuns lists the past runs, newest first
+// GET /runs
+func (s *server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs := make([]*run, 0, len(s.runs))
+	for i := len(s.runs) - 1; i >= 0; i-- {
+		runs = append(runs, s.runs[i])
+	}
+	writeJSON(w, http.StatusOK, runs)
+}
+
+// handleRun returns the status and report of a run
+// GET /runs/{id}
+func (s *server) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/runs/"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "run not found")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rn := range s.runs {
+		if rn.ID == id {
+			writeJSON(w, http.StatusOK, rn)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "run not found")
+}
+
+func (s *server) startRun(p policy, invoker string) *run {
+	s.mu.Lock()
+	rn := &run{
+		ID:      s.nextID,
+		Policy:  p,
+		Status:  runStatusRunning,
+		Started: time.Now(),
+		done:    make(chan struct{}),
+	}
+	s.nextID++
+	s.runs = append(s.runs, rn)
+	if len(s.runs) > serveMaxRuns {
+		s.runs = s.runs[len(s.runs)-serveMaxRuns:]
+	}
+	s.mu.Unlock()
+
+	go func() {
+		defer close(rn.done)
+		if !p.Dry {
+			s.purgeMu.Lock()
+			defer s.purgeMu.Unlock()
+		}
+		start := time.Now()
+		origin := s.origin
+		origin.Invoker = invoker
+		summary, err := runPurge(s.dockerClient, p, origin)
+		s.origin.metrics.observeRun("api", p, summary, err, time.Since(start))
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		finished := time.Now()
+		rn.Finished = &finished
+		if err != nil {
+			rn.Status = runStatusFailed
+			rn.Error = err.Error()
+			return
+		}
+		rn.Status = runStatusDone
+		rn.Summary = summary
+	}()
+	return rn
+}
+
+// nonNil makes sure empty lists are encoded as [] instead of null
+func nonNil(v interface{}) interface{} {
+	switch l := v.(type) {
+	case []container:
+		if l == nil {
+			return []container{}
+		}
+	case []image:
+		if l == nil {
+			return []image{}
+		}
+	case []network:
+		if l == nil {
+			return []network{}
+		}
+	}
+	return v
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeAuthentication(t *testing.T) {
+	docker := newE2EDocker(t)
+	defer docker.close()
+	dockerClient := docker.client(t)
+
+	srv := httptest.NewServer(newServer(dockerClient, "secret", purgeOrigin{}).handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/runs")
+	require.Nil(t, err, "Expected no Error")
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/runs", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	require.Nil(t, err, "Expected no Error")
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestServeEntities(t *testing.T) {
+	docker := newE2EDocker(t)
+	defer docker.close()
+	dockerClient := docker.client(t)
+
+	srv := httptest.NewServer(newServer(dockerClient, "", purgeOrigin{}).handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/entities?kind=containers,images&filter=" + url.QueryEscape(`.Name=="firefox"`))
+	require.Nil(t, err, "Expected no Error")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var entities struct {
+		Containers []container
+		Images     []image
+		Networks   []network
+	}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&entities))
+	require.Len(t, entities.Containers, 1)
+	require.Equal(t, "c1", entities.Containers[0].ID)
+	require.Len(t, entities.Images, 0)
+	require.Nil(t, entities.Networks)
+
+	resp, err = http.Get(srv.URL + "/entities?filter=" + `.IsContainer==`)
+	require.Nil(t, err, "Expected no Error")
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestServeRuns(t *testing.T) {
+	docker := newE2EDocker(t)
+	defer docker.close()
+	dockerClient := docker.client(t)
+
+	srv := httptest.NewServer(newServer(dockerClient, "", purgeOrigin{}).handler())
+	defer srv.Close()
+
+	// dry runs must not delete anything
+	resp, err := http.Post(srv.URL+"/dry-run?wait=true", "application/json", strings.NewReader(`{"filter": ".State == \"exited\"", "containers": true}`))
+	require.Nil(t, err, "Expected no Error")
+	var rn run
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&rn))
+	resp.Body.Close()
+	require.Equal(t, runStatusDone, rn.Status)
+	require.True(t, rn.Policy.Dry)
+	require.Equal(t, 2, rn.Summary.Containers)
+	require.Empty(t, docker.mutations())
+
+	resp, err = http.Post(srv.URL+"/purge", "application/json", strings.NewReader(`{"filter": ".State == \"exited\"", "containers": true}`))
+	require.Nil(t, err, "Expected no Error")
+	resp.Body.Close()
+	require.Equal(t, "/runs/2", resp.Header.Get("Location"))
+
+	require.Nil(t, waitForRun(srv.URL+"/runs/2", &rn))
+	require.Equal(t, runStatusDone, rn.Status)
+	require.Equal(t, 2, rn.Summary.Containers)
+	require.Equal(t, []string{"DELETE /containers/c2", "DELETE /containers/c3"}, docker.mutations())
+
+	resp, err = http.Get(srv.URL + "/runs")
+	require.Nil(t, err, "Expected no Error")
+	var runs []run
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&runs))
+	resp.Body.Close()
+	require.Len(t, runs, 2)
+	require.Equal(t, 2, runs[0].ID)
+}
+
+func waitForRun(url string, rn *run) error {
+	for i := 0; i < 100; i++ {
+		resp, err := http.Get(url)
+		if err != nil {
+			return err
+		}
+		err = json.NewDecoder(resp.Body).Decode(rn)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if rn.Status != runStatusRunning {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func TestServeRejectsUnsafePolicies(t *testing.T) {
+	docker := newE2EDocker(t)
+	defer docker.close()
+	dockerClient := docker.client(t)
+
+	srv := httptest.NewServer(newServer(dockerClient, "", purgeOrigin{}).handler())
+	defer srv.Close()
+
+	for _, body := range []string{``, `{}`, `{"filter": " ", "containers": true}`, `{"filter": ".IsContainer", "trash": true}`} {
+		resp, err := http.Post(srv.URL+"/purge?wait=true", "application/json", strings.NewReader(body))
+		require.Nil(t, err, "Expected no Error")
+		resp.Body.Close()
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode, body)
+	}
+	require.Empty(t, docker.mutations())
+}
+
+func TestIsLoopbackAddr(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1:8080": true,
+		"localhost:8080": true,
+		"[::1]:8080":     true,
+		":8080":          false,
+		"0.0.0.0:8080":   false,
+		"10.0.0.1:8080":  false,
+		"8080":           false,
+	}
+	for addr, loopback := range tests {
+		require.Equal(t, loopback, isLoopbackAddr(addr), addr)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/Eun/docker-purge/purge"
+	"github.com/docker/docker/client"
+)
+
+// entitySource lists the entities that match a filter
+type entitySource interface {
+	containers(filter string) ([]container, error)
+	images(filter string) ([]image, error)
+	networks(filter string) ([]network, error)
+}
+
+// dockerSource selects the entities from the docker daemon
+type dockerSource struct {
+	dockerClient *client.Client
+	// state provides the last time the images were used, nil without a state file
+	state *stateStore
+}
+
+func (s dockerSource) containers(filter string) ([]container, error) {
+	return selectContainers(s.dockerClient, filter)
+}
+
+func (s dockerSource) images(filter string) ([]image, error) {
+	return selectImages(s.dockerClient, s.state, filter)
+}
+
+func (s dockerSource) networks(filter string) ([]network, error) {
+	return selectNetworks(s.dockerClient, filter)
+}
+
+// snapshot holds the documents of all entities of a docker host,
+// filters can be evaluated against it without a docker daemon
+type snapshot struct {
+	Host       string      `json:"host"`
+	Created    time.Time   `json:"created"`
+	Containers []container `json:"containers"`
+	Images     []image     `json:"images"`
+	Networks   []network   `json:"networks"`
+}
+
+// newSnapshot reads all entities from the docker daemon of the host
+func newSnapshot(dockerClient *client.Client, host string, state *stateStore) (*snapshot, error) {
+	snap := snapshot{Host: host, Created: time.Now()}
+	var err error
+	// the sizes are always listed, the filters evaluated against the snapshot are not known yet
+	if snap.Containers, err = purge.ListContainers(context.Background(), dockerClient); err != nil {
+		return nil, err
+	}
+	if snap.Images, err = selectImages(dockerClient, state, ""); err != nil {
+		return nil, err
+	}
+	if snap.Networks, err = selectNetworks(dockerClient, ""); err != nil {
+		return nil, err
+	}
+	snap.setAges()
+	return &snap, nil
+}
+
+func loadSnapshot(path string) (*snapshot, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(buf, &snap); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %s", path, err.Error())
+	}
+	snap.setAges()
+	return &snap, nil
+}
+
+// setAges makes the ages relative to the time the snapshot was taken,
+// so a snapshot selects the same entities no matter when it is evaluated
+func (s *snapshot) setAges() {
+	created := s.Created.Unix()
+	for i := range s.Containers {
+		s.Containers[i].AgeSeconds = created - s.Containers[i].Created
+	}
+	for i := range s.Images {
+		s.Images[i].AgeSeconds = created - s.Images[i].Created
+	}
+	for i := range s.Networks {
+		s.Networks[i].AgeSeconds = created - s.Networks[i].Created
+	}
+}
+
+func (s *snapshot) containers(filter string) ([]container, error) {
+	var selected []container
+	for _, c := range s.Containers {
+		ok, err := purge.Matches(c, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			selected = append(selected, c)
+		}
+	}
+	return selected, nil
+}
+
+func (s *snapshot) images(filter string) ([]image, error) {
+	var selected []image
+	for _, i := range s.Images {
+		ok, err := purge.Matches(i, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			selected = append(selected, i)
+		}
+	}
+	return selected, nil
+}
+
+func (s *snapshot) networks(filter string) ([]network, error) {
+	var selected []network
+	for _, n := range s.Networks {
+		ok, err := purge.Matches(n, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			selected = append(selected, n)
+		}
+	}
+	return selected, nil
+}
+
+func handleSnapshotSave(dockerClient *client.Client, dockerHost string, state *stateStore) {
+	snap, err := newSnapshot(dockerClient, dockerHost, state)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	buf, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	buf = append(buf, '\n')
+	if *snapshotSaveOutArg == "" || *snapshotSaveOutArg == "-" {
+		os.Stdout.Write(buf)
+		return
+	}
+	if err := ioutil.WriteFile(*snapshotSaveOutArg, buf, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stdout, "saved %d containers, %d images, %d networks to %s\n",
+		len(snap.Containers), len(snap.Images), len(snap.Networks), *snapshotSaveOutArg)
+}
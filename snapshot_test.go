@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, removeDir := newTempDir(t)
+	defer removeDir()
+	path := filepath.Join(dir, "snapshot.json")
+
+	docker := newE2EDocker(t)
+	docker.containers[1].SizeRw = 100
+	result := runCLI(t, docker, "snapshot", "save", path)
+	require.Equal(t, 0, result.code, result.stderr)
+	require.Contains(t, result.stdout, "saved 3 containers, 2 images, 1 networks")
+
+	live := runCLI(t, docker, "list", `.IsContainer and .State == "exited" and .SizeBytes > 50`)
+	require.Equal(t, 0, live.code, live.stderr)
+
+	// the snapshot works without a daemon and selects the same entities, even by size,
+	// their ages are relative to the time the snapshot was taken
+	docker.close()
+	offline := runCLI(t, docker, "list", "--from-snapshot", path, `.IsContainer and .State == "exited" and .SizeBytes > 50`)
+	require.Equal(t, 0, offline.code, offline.stderr)
+	ages := regexp.MustCompile(`"AgeSeconds": [0-9]+`)
+	require.Equal(t, ages.ReplaceAllString(live.stdout, ""), ages.ReplaceAllString(offline.stdout, ""))
+	require.Contains(t, offline.stdout, `"Id": "c2"`)
+	require.NotContains(t, offline.stdout, `"Id": "c3"`)
+	snap, err := loadSnapshot(path)
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, docker.host(), snap.Host)
+	require.Contains(t, offline.stdout, fmt.Sprintf(`"AgeSeconds": %d`, snap.Created.Unix()))
+
+	result = runCLI(t, docker, "plan", "--from-snapshot", path, "--networks", `.Name == "ci"`)
+	require.Equal(t, 0, result.code, result.stderr)
+	var pl plan
+	require.Nil(t, json.Unmarshal([]byte(result.stdout), &pl))
+	require.Equal(t, []plannedItem{{ID: "n1", Names: []string{"ci"}}}, pl.Networks)
+	require.Empty(t, pl.Containers)
+
+	networks, err := snap.networks(".IsNetwork")
+	require.Nil(t, err, "Expected no Error")
+	require.Len(t, networks, 1)
+	_, err = snap.containers(".State ==")
+	require.NotNil(t, err)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// stateStore persists information docker does not keep track of itself,
+// like the last time an image was used by a container.
+type stateStore struct {
+	path string
+	mu   sync.Mutex
+	// Images maps an image id to the unix time it was last used by a container
+	Images map[string]int64 `json:"images"`
+	// Trash maps the id of a trashed entity to its original state
+	Trash map[string]*trashEntry `json:"trash,omitempty"`
+}
+
+func loadState(path string) (*stateStore, error) {
+	s := &stateStore{
+		path:   path,
+		Images: make(map[string]int64),
+		Trash:  make(map[string]*trashEntry),
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(buf, s); err != nil {
+		return nil, err
+	}
+	if s.Images == nil {
+		s.Images = make(map[string]int64)
+	}
+	if s.Trash == nil {
+		s.Trash = make(map[string]*trashEntry)
+	}
+	return s, nil
+}
+
+// save writes the state to a temporary file and moves it over the old one,
+// so a crash never leaves a half written state behind
+func (s *stateStore) save() error {
+	s.mu.Lock()
+	buf, err := json.Marshal(s)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// touchImage records that the image was used at the specified time,
+// older timestamps than the recorded one are ignored
+func (s *stateStore) touchImage(imageID string, t int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Images[imageID] < t {
+		s.Images[imageID] = t
+	}
+}
+
+// imageLastUsed returns the unix time the image was last used, 0 if unknown
+func (s *stateStore) imageLastUsed(imageID string) int64 {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Images[imageID]
+}
+
+// refresh updates the state from the current containers and images:
+// images of running containers are used right now, images of stopped containers
+// were used at least when the container was created.
+// Images that were never seen before are considered used at the time they were
+// first seen, images that do not exist anymore are dropped from the state.
+func (s *stateStore) refresh(dockerClient *client.Client) error {
+	now := time.Now().Unix()
+
+	containers, err := dockerClient.ContainerList(context.Background(), containerListOptions)
+	if err != nil {
+		return err
+	}
+	for _, c := range containers {
+		if c.State == "running" {
+			s.touchImage(c.ImageID, now)
+		} else {
+			s.touchImage(c.ImageID, c.Created)
+		}
+	}
+
+	images, err := dockerClient.ImageList(context.Background(), imageListOptions)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing := make(map[string]bool, len(images))
+	for _, i := range images {
+		existing[i.ID] = true
+		if _, ok := s.Images[i.ID]; !ok {
+			s.Images[i.ID] = now
+		}
+	}
+	for id := range s.Images {
+		if !existing[id] {
+			delete(s.Images, id)
+		}
+	}
+	return nil
+}
+
+// refreshState refreshes and saves the state, if a state file was specified
+func refreshState(dockerClient *client.Client, state *stateStore) error {
+	if state == nil {
+		return nil
+	}
+	if err := state.refresh(dockerClient); err != nil {
+		return fmt.Errorf("unable to refresh state: %s", err.Error())
+	}
+	if err := state.save(); err != nil {
+		return fmt.Errorf("unable to save state: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadState(t *testing.T) {
+	dir, removeDir := newTempDir(t)
+	defer removeDir()
+	path := filepath.Join(dir, "state.json")
+
+	// a missing file is an empty state
+	s, err := loadState(path)
+	require.Nil(t, err, "Expected no Error")
+	require.Empty(t, s.Images)
+	require.Empty(t, s.trashEntries())
+
+	s.touchImage("sha256:i1", 100)
+	s.addTrash(&trashEntry{Kind: "container", ID: "c1", Name: "firefox"})
+	require.Nil(t, s.save())
+
+	s, err = loadState(path)
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, map[string]int64{"sha256:i1": 100}, s.Images)
+	require.Len(t, s.trashEntries(), 1)
+	require.Equal(t, "firefox", s.trashEntries()[0].Name)
+
+	// no temporary files are left behind
+	files, err := ioutil.ReadDir(dir)
+	require.Nil(t, err, "Expected no Error")
+	require.Len(t, files, 1)
+
+	require.Nil(t, ioutil.WriteFile(path, []byte(`{"images": `), 0644))
+	_, err = loadState(path)
+	require.NotNil(t, err)
+
+	// states without images or trash can be updated
+	require.Nil(t, ioutil.WriteFile(path, []byte(`{"images": null}`), 0644))
+	s, err = loadState(path)
+	require.Nil(t, err, "Expected no Error")
+	require.NotNil(t, s.Images)
+	require.NotNil(t, s.Trash)
+}
+
+func TestTouchImage(t *testing.T) {
+	s := &stateStore{Images: make(map[string]int64)}
+	s.touchImage("sha256:i1", 200)
+	require.Equal(t, int64(200), s.imageLastUsed("sha256:i1"))
+
+	// older timestamps are ignored
+	s.touchImage("sha256:i1", 100)
+	require.Equal(t, int64(200), s.imageLastUsed("sha256:i1"))
+	s.touchImage("sha256:i1", 300)
+	require.Equal(t, int64(300), s.imageLastUsed("sha256:i1"))
+
+	require.Equal(t, int64(0), s.imageLastUsed("sha256:i2"))
+	var none *stateStore
+	require.Equal(t, int64(0), none.imageLastUsed("sha256:i1"))
+}
+
+func TestRefreshState(t *testing.T) {
+	docker := newFakeDocker(t)
+	defer docker.close()
+	docker.containers = []types.Container{
+		{ID: "c1", ImageID: "sha256:i1", State: "running", Created: 100},
+		{ID: "c2", ImageID: "sha256:i2", State: "exited", Created: 100},
+	}
+	docker.images = []types.ImageSummary{{ID: "sha256:i1"}, {ID: "sha256:i2"}, {ID: "sha256:i3"}}
+
+	s := &stateStore{Images: map[string]int64{"sha256:i2": 50, "sha256:gone": 50}}
+	before := time.Now().Unix()
+	require.Nil(t, s.refresh(docker.client(t)))
+
+	// images of running containers are used now, of stopped containers when they were created,
+	// new images when they were first seen and deleted images are dropped
+	require.True(t, s.Images["sha256:i1"] >= before)
+	require.Equal(t, int64(100), s.Images["sha256:i2"])
+	require.True(t, s.Images["sha256:i3"] >= before)
+	require.Len(t, s.Images, 3)
+}
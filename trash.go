@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Eun/docker-purge/purge"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// trashPrefix is the name prefix of trashed containers and the repository of trashed images
+const trashPrefix = "docker-purge-trash"
+
+// trashEntry records how a trashed entity looked before it was trashed
+type trashEntry struct {
+	Kind string `json:"kind"`
+	ID   string `json:"id"`
+	// Name is the original name of a container
+	Name string `json:"name,omitempty"`
+	// Running reports whether a container was running before it was trashed
+	Running bool `json:"running,omitempty"`
+	// Tags are the original tags of an image
+	Tags []string `json:"tags,omitempty"`
+	// Trashed is the unix time the entity was trashed
+	Trashed int64 `json:"trashed"`
+}
+
+func (s *stateStore) addTrash(e *trashEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Trash[e.ID] = e
+}
+
+func (s *stateStore) removeTrash(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Trash, id)
+}
+
+// isTrashed reports whether the entity is in the trash
+func (s *stateStore) isTrashed(id string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.Trash[id]
+	return ok
+}
+
+// trashEntries returns the trashed entities, oldest first
+func (s *stateStore) trashEntries() []*trashEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]*trashEntry, 0, len(s.Trash))
+	for _, e := range s.Trash {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Trashed != entries[j].Trashed {
+			return entries[i].Trashed < entries[j].Trashed
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries
+}
+
+// findTrash returns the trashed entity with the id or id prefix
+func (s *stateStore) findTrash(id string) (*trashEntry, error) {
+	id = strings.TrimPrefix(id, "sha256:")
+	var found *trashEntry
+	for _, e := range s.trashEntries() {
+		if strings.HasPrefix(strings.TrimPrefix(e.ID, "sha256:"), id) {
+			if found != nil {
+				return nil, fmt.Errorf("`%s' matches more than one trashed entity", id)
+			}
+			found = e
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no trashed entity with id `%s'", id)
+	}
+	return found, nil
+}
+
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// trashImageTag returns the tag that keeps a trashed image around
+func trashImageTag(id string) string {
+	return trashPrefix + "/" + shortID(id) + ":latest"
+}
+
+// trashContainer stops the container and renames it into the trash
+func trashContainer(dockerClient *client.Client, state *stateStore, container container, killContainerSignal string, stopContainers bool) error {
+	if state == nil {
+		return fmt.Errorf("unable to trash container %s: no state file specified", container.ID)
+	}
+	details, err := dockerClient.ContainerInspect(context.Background(), container.ID)
+	if err != nil {
+		return fmt.Errorf("unable to inspect container %s: %s", container.ID, err.Error())
+	}
+	if details.State.Running {
+		if killContainerSignal == "" && !stopContainers {
+			return fmt.Errorf("unable to trash running container %s, use --container.stop or --container.kill", container.ID)
+		}
+		if err := purge.StopContainer(context.Background(), dockerClient, container.ID, killContainerSignal, stopContainers); err != nil {
+			return err
+		}
+	}
+	// record the entry first, so a crash never leaves a renamed container behind that cannot be restored
+	state.addTrash(&trashEntry{
+		Kind:    "container",
+		ID:      container.ID,
+		Name:    strings.TrimPrefix(details.Name, "/"),
+		Running: details.State.Running,
+		Trashed: time.Now().Unix(),
+	})
+	if err := state.save(); err != nil {
+		state.removeTrash(container.ID)
+		return fmt.Errorf("unable to save state: %s", err.Error())
+	}
+	if err := dockerClient.ContainerRename(context.Background(), container.ID, trashPrefix+"."+shortID(container.ID)); err != nil {
+		state.removeTrash(container.ID)
+		state.save()
+		return fmt.Errorf("unable to trash container %s: %s", container.ID, err.Error())
+	}
+	return nil
+}
+
+// trashImage moves the tags of the image into the trash repository,
+// images without tags are tagged as well, so docker image prune does not delete them
+func trashImage(dockerClient *client.Client, state *stateStore, image image) error {
+	if state == nil {
+		return fmt.Errorf("unable to trash image %s: no state file specified", image.ID)
+	}
+	var tags []string
+	for _, tag := range image.RepoTags {
+		if tag != "<none>:<none>" {
+			tags = append(tags, tag)
+		}
+	}
+	state.addTrash(&trashEntry{
+		Kind:    "image",
+		ID:      image.ID,
+		Tags:    tags,
+		Trashed: time.Now().Unix(),
+	})
+	if err := state.save(); err != nil {
+		state.removeTrash(image.ID)
+		return fmt.Errorf("unable to save state: %s", err.Error())
+	}
+	if err := dockerClient.ImageTag(context.Background(), image.ID, trashImageTag(image.ID)); err != nil {
+		state.removeTrash(image.ID)
+		state.save()
+		return fmt.Errorf("unable to trash image %s: %s", image.ID, err.Error())
+	}
+	for _, tag := range tags {
+		// the image is still referenced by the trash tag, so this only removes the tag
+		if _, err := dockerClient.ImageRemove(context.Background(), tag, types.ImageRemoveOptions{}); err != nil {
+			return fmt.Errorf("unable to untag image %s: %s", tag, err.Error())
+		}
+	}
+	return nil
+}
+
+// restoreTrash puts the trashed entity back the way it was
+func restoreTrash(dockerClient *client.Client, state *stateStore, e *trashEntry) error {
+	switch e.Kind {
+	case "container":
+		if err := dockerClient.ContainerRename(context.Background(), e.ID, e.Name); err != nil {
+			return fmt.Errorf("unable to rename container %s to %s: %s", e.ID, e.Name, err.Error())
+		}
+		if e.Running {
+			if err := dockerClient.ContainerStart(context.Background(), e.ID, types.ContainerStartOptions{}); err != nil {
+				return fmt.Errorf("unable to start container %s: %s", e.ID, err.Error())
+			}
+		}
+	case "image":
+		for _, tag := range e.Tags {
+			if err := dockerClient.ImageTag(context.Background(), e.ID, tag); err != nil {
+				return fmt.Errorf("unable to tag image %s as %s: %s", e.ID, tag, err.Error())
+			}
+		}
+		// docker deletes an image together with its last tag unless a container uses it,
+		// so unused untagged images keep the trash tag
+		remove := len(e.Tags) > 0
+		if !remove {
+			used, err := imageUsed(dockerClient, e.ID)
+			if err != nil {
+				return err
+			}
+			if !used {
+				fmt.Fprintf(os.Stderr, "image %s keeps the tag %s, docker would delete it with its last tag\n", e.ID, trashImageTag(e.ID))
+			}
+			remove = used
+		}
+		if remove {
+			if _, err := dockerClient.ImageRemove(context.Background(), trashImageTag(e.ID), types.ImageRemoveOptions{}); err != nil {
+				return fmt.Errorf("unable to untag image %s: %s", trashImageTag(e.ID), err.Error())
+			}
+		}
+	}
+	state.removeTrash(e.ID)
+	return state.save()
+}
+
+// imageUsed reports whether a container uses the image
+func imageUsed(dockerClient *client.Client, id string) (bool, error) {
+	containers, err := dockerClient.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	if err != nil {
+		return false, err
+	}
+	for _, c := range containers {
+		if c.ImageID == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func handleEmptyTrash(dockerClient *client.Client, origin purgeOrigin) {
+	origin.Rule = "empty-trash"
+	origin.Dry = *dryRunFlag
+	summary, err := emptyTrash(dockerClient, *emptyTrashOlderThanFlag, origin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if summary.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// emptyTrash deletes the entities that were trashed longer than olderThan ago,
+// entities that do not exist anymore are dropped from the trash
+func emptyTrash(dockerClient *client.Client, olderThan time.Duration, origin purgeOrigin) (*purgeSummary, error) {
+	var summary purgeSummary
+	deadline := time.Now().Add(-olderThan).Unix()
+	due := make(map[string]*trashEntry)
+	missing := make(map[string]bool)
+	for _, e := range origin.state.trashEntries() {
+		if e.Trashed <= deadline {
+			due[e.ID] = e
+			missing[e.ID] = true
+		}
+	}
+	origin.trashed = due
+
+	// containers first, they might keep trashed images alive
+	containers, err := selectContainers(dockerClient, "")
+	if err != nil {
+		return nil, err
+	}
+	var trashedContainers []container
+	for _, c := range containers {
+		if e := due[c.ID]; e != nil {
+			// the output and the audit log name the container, not the trash
+			c.Name = e.Name
+			c.Names = []string{"/" + e.Name}
+			trashedContainers = append(trashedContainers, c)
+			delete(missing, c.ID)
+		}
+	}
+	summary.Add(deleteContainers(dockerClient, trashedContainers, containerRemoveOptions, "", false, origin))
+
+	images, err := selectImages(dockerClient, origin.state, "")
+	if err != nil {
+		return nil, err
+	}
+	var trashedImages []image
+	for _, i := range images {
+		if e := due[i.ID]; e != nil {
+			i.RepoTags = e.Tags
+			if len(i.RepoTags) == 0 {
+				i.RepoTags = []string{"<none>:<none>"}
+			}
+			trashedImages = append(trashedImages, i)
+			delete(missing, i.ID)
+		}
+	}
+	summary.Add(deleteImages(dockerClient, trashedImages, imageRemoveOptions, origin))
+
+	if origin.Dry {
+		return &summary, nil
+	}
+	for _, result := range summary.Results {
+		if result.Error == "" {
+			origin.state.removeTrash(result.ID)
+		}
+	}
+	// whatever is left does not exist anymore
+	for id := range missing {
+		origin.state.removeTrash(id)
+	}
+	return &summary, origin.state.save()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrashRestore(t *testing.T) {
+	docker := newFakeDocker(t)
+	defer docker.close()
+	docker.containers = []types.Container{{ID: "c1", Names: []string{"/firefox"}, State: "exited"}}
+	docker.images = []types.ImageSummary{{ID: "sha256:0123456789abcdef", RepoTags: []string{"firefox:latest"}}}
+	dockerClient := docker.client(t)
+
+	dir, removeDir := newTempDir(t)
+	defer removeDir()
+	state, err := loadState(filepath.Join(dir, "state.json"))
+	require.Nil(t, err, "Expected no Error")
+
+	c := container{IsContainer: true, Container: docker.containers[0]}
+	i := image{IsImage: true, ImageSummary: docker.images[0]}
+	origin := purgeOrigin{Rule: "cli", Trash: true, state: state}
+	require.Len(t, deleteContainers(dockerClient, []container{c}, types.ContainerRemoveOptions{}, "", false, origin), 1)
+	require.Len(t, deleteImages(dockerClient, []image{i}, types.ImageRemoveOptions{}, origin), 1)
+	require.Equal(t, []string{
+		"POST /containers/c1/rename?name=docker-purge-trash.c1",
+		"POST /images/sha256:0123456789abcdef/tag?repo=docker-purge-trash%2F0123456789ab&tag=latest",
+		"DELETE /images/firefox:latest?noprune=1",
+	}, docker.mutations())
+	require.Equal(t, []string{"docker-purge-trash/0123456789ab:latest"}, docker.images[0].RepoTags)
+
+	// trashed entities are not trashed twice
+	require.Empty(t, deleteContainers(dockerClient, []container{c}, types.ContainerRemoveOptions{}, "", false, origin))
+
+	// the trash survives a restart
+	state, err = loadState(filepath.Join(dir, "state.json"))
+	require.Nil(t, err, "Expected no Error")
+	require.Len(t, state.trashEntries(), 2)
+
+	e, err := state.findTrash("0123")
+	require.Nil(t, err, "Expected no Error")
+	require.Nil(t, restoreTrash(dockerClient, state, e))
+	e, err = state.findTrash("c1")
+	require.Nil(t, err, "Expected no Error")
+	require.Nil(t, restoreTrash(dockerClient, state, e))
+	require.Equal(t, []string{
+		"POST /images/sha256:0123456789abcdef/tag?repo=firefox&tag=latest",
+		"DELETE /images/docker-purge-trash/0123456789ab:latest?noprune=1",
+		"POST /containers/c1/rename?name=firefox",
+	}, docker.mutations()[3:])
+	require.Equal(t, []string{"firefox:latest"}, docker.images[0].RepoTags)
+	require.Equal(t, []string{"/firefox"}, docker.containers[0].Names)
+	require.Empty(t, state.trashEntries())
+
+	_, err = state.findTrash("c1")
+	require.NotNil(t, err)
+}
+
+func TestTrashUntaggedImage(t *testing.T) {
+	docker := newFakeDocker(t)
+	defer docker.close()
+	docker.images = []types.ImageSummary{{ID: "sha256:0123456789abcdef", RepoTags: []string{"<none>:<none>"}}}
+	dockerClient := docker.client(t)
+
+	dir, removeDir := newTempDir(t)
+	defer removeDir()
+	state, err := loadState(filepath.Join(dir, "state.json"))
+	require.Nil(t, err, "Expected no Error")
+
+	// the trash tag keeps the image from being pruned
+	i := image{IsImage: true, ImageSummary: docker.images[0]}
+	require.Len(t, deleteImages(dockerClient, []image{i}, types.ImageRemoveOptions{}, purgeOrigin{Rule: "cli", Trash: true, state: state}), 1)
+	require.Equal(t, []string{
+		"POST /images/sha256:0123456789abcdef/tag?repo=docker-purge-trash%2F0123456789ab&tag=latest",
+	}, docker.mutations())
+	require.Equal(t, []string{"docker-purge-trash/0123456789ab:latest"}, docker.images[0].RepoTags)
+
+	// removing the last tag would delete the unused image
+	e, err := state.findTrash("0123")
+	require.Nil(t, err, "Expected no Error")
+	require.Nil(t, restoreTrash(dockerClient, state, e))
+	require.Len(t, docker.mutations(), 1)
+	require.Equal(t, []string{"docker-purge-trash/0123456789ab:latest"}, docker.images[0].RepoTags)
+	require.Empty(t, state.trashEntries())
+
+	// an image used by a container survives losing its last tag
+	docker.containers = []types.Container{{ID: "c1", ImageID: "sha256:0123456789abcdef", State: "exited"}}
+	i.RepoTags = []string{"<none>:<none>"}
+	require.Len(t, deleteImages(dockerClient, []image{i}, types.ImageRemoveOptions{}, purgeOrigin{Rule: "cli", Trash: true, state: state}), 1)
+	e, err = state.findTrash("0123")
+	require.Nil(t, err, "Expected no Error")
+	require.Nil(t, restoreTrash(dockerClient, state, e))
+	require.Equal(t, "DELETE /images/docker-purge-trash/0123456789ab:latest?noprune=1", docker.mutations()[len(docker.mutations())-1])
+	require.Equal(t, []string{"<none>:<none>"}, docker.images[0].RepoTags)
+}
+
+func TestEmptyTrash(t *testing.T) {
+	docker := newFakeDocker(t)
+	defer docker.close()
+	docker.containers = []types.Container{{ID: "c1", Names: []string{"/firefox"}, State: "exited"}}
+	docker.images = []types.ImageSummary{{ID: "sha256:0123456789abcdef", RepoTags: []string{"firefox:latest"}}}
+	dockerClient := docker.client(t)
+
+	dir, removeDir := newTempDir(t)
+	defer removeDir()
+	state, err := loadState(filepath.Join(dir, "state.json"))
+	require.Nil(t, err, "Expected no Error")
+	archive, err := openImageArchive(filepath.Join(dir, "archive"), 0, 0)
+	require.Nil(t, err, "Expected no Error")
+	recorder, err := openContainerRecorder(filepath.Join(dir, "records"), false, false)
+	require.Nil(t, err, "Expected no Error")
+
+	c := container{IsContainer: true, Container: docker.containers[0]}
+	i := image{IsImage: true, ImageSummary: docker.images[0]}
+	origin := purgeOrigin{Rule: "cli", Trash: true, state: state, archive: archive, recorder: recorder}
+	require.Len(t, deleteContainers(dockerClient, []container{c}, types.ContainerRemoveOptions{}, "", false, origin), 1)
+	require.Len(t, deleteImages(dockerClient, []image{i}, types.ImageRemoveOptions{}, origin), 1)
+
+	origin.Rule = "empty-trash"
+	origin.Trash = false
+	summary, err := emptyTrash(dockerClient, 0, origin)
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, []string{"/firefox"}, summary.Results[0].Names)
+	require.Empty(t, docker.containerIDs())
+	require.Empty(t, state.trashEntries())
+
+	// the container is recorded and the image archived as they were before they were trashed
+	record, err := recorder.find("firefox")
+	require.Nil(t, err, "Expected no Error")
+	require.Equal(t, "c1", record.Container.ID)
+	require.Equal(t, []string{"sha256:0123456789abcdef"}, docker.saved)
+	e, err := archive.find("firefox:latest")
+	require.Nil(t, err, "Expected no Error")
+	require.True(t, e.Retag)
+
+	// loading the archive tags the image again
+	docker.update(func() {
+		docker.images = []types.ImageSummary{{ID: "sha256:0123456789abcdef", RepoTags: []string{"<none>:<none>"}}}
+	})
+	require.Nil(t, archive.load(dockerClient, e))
+	require.Equal(t, []string{"firefox:latest"}, docker.image("sha256:0123456789abcdef").RepoTags)
+}
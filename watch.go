@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Eun/docker-purge/jq"
+	"github.com/Eun/docker-purge/purge"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+)
+
+// watchReconnectDelay is the time to wait before subscribing to the events again after an error
+const watchReconnectDelay = 5 * time.Second
+
+// watchTick is the interval at which the pending checks are run once they are due
+var watchTick = time.Second
+
+// watchKey identifies an entity that should be checked against the filter
+type watchKey struct {
+	kind string
+	// ref is the id of the entity, for images it can also be a tag
+	ref string
+}
+
+// watchPending holds the entities that will be checked once their grace period is over,
+// another event for a pending entity restarts its grace period
+type watchPending map[watchKey]time.Time
+
+func (w watchPending) add(keys []watchKey, due time.Time) {
+	for _, key := range keys {
+		w[key] = due
+	}
+}
+
+// due removes and returns the entities whose grace period is over
+func (w watchPending) due(now time.Time) []watchKey {
+	var keys []watchKey
+	for key, due := range w {
+		if now.After(due) {
+			delete(w, key)
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func handleWatch(dockerClient *client.Client, origin purgeOrigin) {
+	p := flagPolicy()
+	if p.Dry {
+		fmt.Fprintln(os.Stdout, "Dry mode on")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	emergency := p
+	emergency.Filter = *pressureFilterFlag
+	pressure, err := (&pressureConfig{
+		MinFree:         *pressureMinFreeFlag,
+		MinFreeInodes:   *pressureMinFreeInodesFlag,
+		HighWater:       *pressureHighWaterFlag,
+		HighWaterInodes: *pressureHighWaterInodesFlag,
+		Interval:        *pressureIntervalFlag,
+		Path:            *pressurePathFlag,
+		Policy:          emergency,
+	}).parse()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+	if pressure != nil {
+		if !jq.IsValidFilter(emergency.Filter) {
+			fmt.Fprintf(os.Stderr, "Invalid filter `%s'\n", emergency.Filter)
+			os.Exit(1)
+		}
+		go watchPressure(ctx, dockerClient, pressure, origin)
+	}
+
+	origin.Rule = "watch"
+	watch(ctx, dockerClient, p, origin, *watchGraceFlag, *watchResyncFlag)
+}
+
+// watch subscribes to the docker events and purges entities that match the policy
+// after the grace period, every resync interval a full purge is run.
+// It returns when the context is done.
+func watch(ctx context.Context, dockerClient *client.Client, p policy, origin purgeOrigin, grace, resync time.Duration) {
+	resyncWatch(dockerClient, p, origin)
+
+	var resyncChan <-chan time.Time
+	if resync > 0 {
+		resyncTicker := time.NewTicker(resync)
+		defer resyncTicker.Stop()
+		resyncChan = resyncTicker.C
+	}
+
+	ticker := time.NewTicker(watchTick)
+	defer ticker.Stop()
+
+	pending := make(watchPending)
+
+	for {
+		messages, errs := subscribeWatchEvents(ctx, dockerClient)
+	events:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-messages:
+				pending.add(handleWatchEvent(dockerClient, origin.state, p, msg), time.Now().Add(grace))
+			case now := <-ticker.C:
+				for _, key := range pending.due(now) {
+					if err := checkWatchKey(dockerClient, key, p, origin); err != nil {
+						fmt.Fprintf(os.Stderr, "unable to check %s %s: %s\n", key.kind, key.ref, err.Error())
+					}
+				}
+			case <-resyncChan:
+				resyncWatch(dockerClient, p, origin)
+			case err := <-errs:
+				if ctx.Err() != nil {
+					return
+				}
+				fmt.Fprintf(os.Stderr, "lost connection to docker events: %s\n", err.Error())
+				break events
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watchReconnectDelay):
+		}
+		// events might have been missed while we were not connected
+		resyncWatch(dockerClient, p, origin)
+	}
+}
+
+func subscribeWatchEvents(ctx context.Context, dockerClient *client.Client) (<-chan events.Message, <-chan error) {
+	args := filters.NewArgs()
+	args.Add("type", events.ContainerEventType)
+	args.Add("type", events.ImageEventType)
+	args.Add("type", events.NetworkEventType)
+	return dockerClient.Events(ctx, types.EventsOptions{Filters: args})
+}
+
+// handleWatchEvent returns the entities that need to be checked because of the event,
+// the start of a container is recorded in the state if there is one
+func handleWatchEvent(dockerClient *client.Client, state *stateStore, p policy, msg events.Message) []watchKey {
+	switch msg.Type {
+	case events.ContainerEventType:
+		switch msg.Action {
+		case "start":
+			if state != nil {
+				touchContainerImage(dockerClient, state, msg.Actor.ID, msg.Time)
+			}
+		case "die":
+			if p.Containers {
+				return []watchKey{{kind: events.ContainerEventType, ref: msg.Actor.ID}}
+			}
+		case "destroy":
+			// the image of the container might not be in use anymore
+			if p.Images && msg.Actor.Attributes["image"] != "" {
+				return []watchKey{{kind: events.ImageEventType, ref: msg.Actor.Attributes["image"]}}
+			}
+		}
+	case events.ImageEventType:
+		switch msg.Action {
+		case "untag", "tag", "pull", "import", "load":
+			if p.Images {
+				return []watchKey{{kind: events.ImageEventType, ref: msg.Actor.ID}}
+			}
+		}
+	case events.NetworkEventType:
+		switch msg.Action {
+		case "disconnect", "create":
+			if p.Networks {
+				return []watchKey{{kind: events.NetworkEventType, ref: msg.Actor.ID}}
+			}
+		}
+	}
+	return nil
+}
+
+// touchContainerImage records the usage of the image of the container in the state
+func touchContainerImage(dockerClient *client.Client, state *stateStore, containerID string, t int64) {
+	details, err := dockerClient.ContainerInspect(context.Background(), containerID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to inspect container %s: %s\n", containerID, err.Error())
+		return
+	}
+	state.touchImage(details.Image, t)
+	if err := state.save(); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to save state: %s\n", err.Error())
+	}
+}
+
+// checkWatchKey purges the entity if it still exists and matches the filter,
+// only the document of that entity is evaluated
+func checkWatchKey(dockerClient *client.Client, key watchKey, p policy, origin purgeOrigin) error {
+	ctx := context.Background()
+	origin.Filter = p.Filter
+	origin.Dry = p.Dry
+	origin.Trash = p.Trash
+	switch key.kind {
+	case events.ContainerEventType:
+		c, err := purge.SelectContainer(ctx, dockerClient, key.ref, p.Filter)
+		if err != nil || c == nil {
+			return err
+		}
+		purgeContainer(dockerClient, c, origin)
+	case events.ImageEventType:
+		// the ref of a destroyed container is the image as it was specified, e.g. alpine or an id prefix
+		details, _, err := dockerClient.ImageInspectWithRaw(ctx, key.ref)
+		if client.IsErrImageNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		i, err := purge.SelectImage(ctx, dockerClient, details.ID, p.Filter, origin.state.imageLastUsed)
+		if err != nil || i == nil {
+			return err
+		}
+		purgeImage(dockerClient, i, origin)
+	case events.NetworkEventType:
+		n, err := purge.SelectNetwork(ctx, dockerClient, key.ref, p.Filter)
+		if err != nil || n == nil {
+			return err
+		}
+		purgeNetwork(dockerClient, n, origin)
+	}
+	return nil
+}
+
+// resyncWatch runs a full purge, errors are reported but do not stop the watch
+func resyncWatch(dockerClient *client.Client, p policy, origin purgeOrigin) {
+	if err := refreshState(dockerClient, origin.state); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+	}
+	start := time.Now()
+	summary, err := runPurge(dockerClient, p, origin)
+	origin.metrics.observeRun("watch", p, summary, err, time.Since(start))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to purge: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchPending(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	c1 := watchKey{kind: events.ContainerEventType, ref: "c1"}
+	c2 := watchKey{kind: events.ContainerEventType, ref: "c2"}
+
+	pending := make(watchPending)
+	pending.add([]watchKey{c1, c2}, start.Add(30*time.Second))
+	// another event for c1 restarts its grace period
+	pending.add([]watchKey{c1}, start.Add(40*time.Second))
+
+	require.Empty(t, pending.due(start.Add(29*time.Second)))
+	require.Equal(t, []watchKey{c2}, pending.due(start.Add(31*time.Second)))
+	require.Empty(t, pending.due(start.Add(35*time.Second)))
+	require.Equal(t, []watchKey{c1}, pending.due(start.Add(41*time.Second)))
+	require.Empty(t, pending)
+}
+
+func TestHandleWatchEvent(t *testing.T) {
+	all := policy{Containers: true, Images: true, Networks: true}
+	tests := []struct {
+		Policy  policy
+		Message events.Message
+		Keys    []watchKey
+	}{
+		{all, events.Message{Type: "container", Action: "die", Actor: events.Actor{ID: "c1"}},
+			[]watchKey{{kind: "container", ref: "c1"}}},
+		{all, events.Message{Type: "container", Action: "destroy", Actor: events.Actor{ID: "c1", Attributes: map[string]string{"image": "alpine"}}},
+			[]watchKey{{kind: "image", ref: "alpine"}}},
+		{all, events.Message{Type: "container", Action: "create", Actor: events.Actor{ID: "c1"}}, nil},
+		{all, events.Message{Type: "image", Action: "untag", Actor: events.Actor{ID: "sha256:i1"}},
+			[]watchKey{{kind: "image", ref: "sha256:i1"}}},
+		{all, events.Message{Type: "image", Action: "delete", Actor: events.Actor{ID: "sha256:i1"}}, nil},
+		{all, events.Message{Type: "network", Action: "disconnect", Actor: events.Actor{ID: "n1"}},
+			[]watchKey{{kind: "network", ref: "n1"}}},
+		// kinds the policy is not limited to are ignored
+		{policy{Images: true}, events.Message{Type: "container", Action: "die", Actor: events.Actor{ID: "c1"}}, nil},
+		{policy{Containers: true}, events.Message{Type: "container", Action: "destroy", Actor: events.Actor{ID: "c1", Attributes: map[string]string{"image": "alpine"}}}, nil},
+		{policy{Containers: true}, events.Message{Type: "network", Action: "disconnect", Actor: events.Actor{ID: "n1"}}, nil},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.Keys, handleWatchEvent(nil, nil, test.Policy, test.Message), "%s %s", test.Message.Type, test.Message.Action)
+	}
+}
+
+func TestWatchEvents(t *testing.T) {
+	defer func(tick time.Duration) { watchTick = tick }(watchTick)
+	watchTick = 10 * time.Millisecond
+
+	docker := newFakeDocker(t)
+	defer docker.close()
+	docker.containers = []types.Container{
+		{ID: "c1", Names: []string{"/alpine"}, Image: "alpine", ImageID: "sha256:i1", State: "running"},
+		{ID: "c2", Names: []string{"/firefox"}, Image: "firefox", ImageID: "sha256:i2", State: "running"},
+	}
+	docker.images = []types.ImageSummary{
+		{ID: "sha256:i1", RepoTags: []string{"alpine:latest"}},
+		{ID: "sha256:i2", RepoTags: []string{"firefox:latest"}},
+	}
+	dockerClient := docker.client(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p := policy{Filter: `(.IsContainer and .State == "exited") or (.IsImage and (.InUse | not))`, Containers: true, Images: true}
+		watch(ctx, dockerClient, p, purgeOrigin{Rule: "watch"}, 50*time.Millisecond, 0)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// events are only delivered once watch subscribed, which it does after the initial purge
+	docker.emit(events.Message{Type: "container", Action: "create", Actor: events.Actor{ID: "c3"}})
+	docker.update(func() { docker.container("c2").State = "exited" })
+	docker.emit(events.Message{Type: "container", Action: "die", Actor: events.Actor{ID: "c2"}})
+	docker.emit(events.Message{Type: "container", Action: "die", Actor: events.Actor{ID: "c2"}})
+	requireEventually(t, func() bool { return len(docker.mutations()) > 0 })
+	require.Equal(t, []string{"DELETE /containers/c2"}, docker.mutations())
+
+	// the image of a destroyed container is referenced as it was specified on docker run
+	docker.update(func() { docker.containers = nil })
+	docker.emit(events.Message{Type: "container", Action: "destroy", Actor: events.Actor{ID: "c1", Attributes: map[string]string{"image": "alpine"}}})
+	requireEventually(t, func() bool { return len(docker.mutations()) > 1 })
+	require.Equal(t, []string{"DELETE /containers/c2", "DELETE /images/sha256:i1?noprune=1"}, docker.mutations())
+}
+
+// requireEventually fails the test if the condition is not met within two seconds
+func requireEventually(t *testing.T, condition func() bool) {
+	for i := 0; i < 200; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not met")
+}